@@ -438,12 +438,13 @@ func (b *SimulatedBackend) EstimateGas(ctx context.Context, call ethereum.CallMs
 	defer b.mu.Unlock()
 
 	// Determine the lowest and highest possible gas limits to binary search in between
+	txGas := b.config.TxGas(b.pendingBlock.Number())
 	var (
-		lo  uint64 = params.TxGas - 1
+		lo  uint64 = txGas - 1
 		hi  uint64
 		cap uint64
 	)
-	if call.Gas >= params.TxGas {
+	if call.Gas >= txGas {
 		hi = call.Gas
 	} else {
 		hi = b.pendingBlock.GasLimit()
@@ -3108,3 +3108,76 @@ func TestEIP2718Transition(t *testing.T) {
 
 	}
 }
+
+// TestEIP2930AccessListPrewarming checks that the slots of an access list are
+// charged as intrinsic gas exactly once and are warm on their first SLOAD.
+func TestEIP2930AccessListPrewarming(t *testing.T) {
+	var (
+		aa = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+
+		engine = ethash.NewFaker()
+		db     = rawdb.NewMemoryDatabase()
+
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000)
+		gspec   = &Genesis{
+			Config: params.YoloV3ChainConfig,
+			Alloc: GenesisAlloc{
+				address: {Balance: funds},
+				// The address 0xAAAA sloads 0x01 and 0x02
+				aa: {
+					Code: []byte{
+						byte(vm.PUSH1), 0x01,
+						byte(vm.SLOAD),
+						byte(vm.PUSH1), 0x02,
+						byte(vm.SLOAD),
+					},
+					Nonce:   0,
+					Balance: big.NewInt(0),
+				},
+			},
+		}
+		genesis    = gspec.MustCommit(db)
+		accessList = types.AccessList{{
+			Address:     aa,
+			StorageKeys: []common.Hash{common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(2))},
+		}}
+	)
+	intrinsic, err := IntrinsicGas(nil, accessList, false, true, true)
+	if err != nil {
+		t.Fatalf("failed to compute intrinsic gas: %v", err)
+	}
+	if want := params.TxGas + params.TxAccessListAddressGas + 2*params.TxAccessListStorageKeyGas; intrinsic != want {
+		t.Fatalf("intrinsic gas mismatch: have %d, want %d", intrinsic, want)
+	}
+	blocks, _ := GenerateChain(gspec.Config, genesis, engine, db, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+
+		signer := types.LatestSigner(gspec.Config)
+		tx, _ := types.SignNewTx(key, signer, &types.AccessListTx{
+			ChainID:    gspec.Config.ChainID,
+			Nonce:      0,
+			To:         &aa,
+			Gas:        40000,
+			GasPrice:   big.NewInt(1),
+			AccessList: accessList,
+		})
+		b.AddTx(tx)
+	})
+	diskdb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(diskdb)
+
+	chain, err := NewBlockChain(diskdb, nil, gspec.Config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	// Expected gas is intrinsic + 2 * push + 2 hot loads, since both slots are in the access list
+	expected := intrinsic + vm.GasFastestStep*2 + vm.WarmStorageReadCostEIP2929*2
+	if used := chain.GetBlockByNumber(1).GasUsed(); used != expected {
+		t.Fatalf("incorrect amount of gas spent: expected %d, got %d", expected, used)
+	}
+}
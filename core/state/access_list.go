@@ -81,6 +81,23 @@ func (al *accessList) AddAddress(address common.Address) bool {
 	return true
 }
 
+// AddAddresses adds a batch of addresses to the access list, returning the ones
+// which were not previously in the list.
+func (al *accessList) AddAddresses(addresses []common.Address) []common.Address {
+	// Size the map for the batch up front, saving rehashes while it grows
+	if len(al.addresses) == 0 {
+		al.addresses = make(map[common.Address]int, len(addresses))
+	}
+	added := make([]common.Address, 0, len(addresses))
+	for _, address := range addresses {
+		if _, present := al.addresses[address]; !present {
+			al.addresses[address] = -1
+			added = append(added, address)
+		}
+	}
+	return added
+}
+
 // AddSlot adds the specified (addr, slot) combo to the access list.
 // Return values are:
 // - address added
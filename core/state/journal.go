@@ -138,6 +138,9 @@ type (
 		address *common.Address
 		slot    *common.Hash
 	}
+	accessListAddAccountsChange struct {
+		addresses []common.Address
+	}
 )
 
 func (ch createObjectChange) revert(s *StateDB) {
@@ -260,6 +263,16 @@ func (ch accessListAddAccountChange) dirtied() *common.Address {
 	return nil
 }
 
+func (ch accessListAddAccountsChange) revert(s *StateDB) {
+	for _, addr := range ch.addresses {
+		s.accessList.DeleteAddress(addr)
+	}
+}
+
+func (ch accessListAddAccountsChange) dirtied() *common.Address {
+	return nil
+}
+
 func (ch accessListAddSlotChange) revert(s *StateDB) {
 	s.accessList.DeleteSlot(*ch.address, *ch.slot)
 }
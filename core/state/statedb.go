@@ -18,6 +18,7 @@
 package state
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
@@ -785,6 +786,19 @@ func (s *StateDB) GetRefund() uint64 {
 	return s.refund
 }
 
+// TouchedEmptyAccounts returns the sorted addresses of the empty accounts touched
+// since the last Finalise. These are deleted when finalising with EIP-161 rules.
+func (s *StateDB) TouchedEmptyAccounts() []common.Address {
+	var addrs []common.Address
+	for addr := range s.journal.dirties {
+		if obj, exist := s.stateObjects[addr]; exist && !obj.deleted && obj.empty() {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	return addrs
+}
+
 // Finalise finalises the state by removing the s destructed objects and clears
 // the journal as well as the refunds. Finalise, however, will not push any updates
 // into the tries just yet. Only IntermediateRoot or Commit will do that.
@@ -1016,6 +1030,14 @@ func (s *StateDB) AddAddressToAccessList(addr common.Address) {
 	}
 }
 
+// AddAddressesToAccessList adds a batch of addresses to the access list. It is
+// cheaper than adding them one by one, journalling the batch as a single change.
+func (s *StateDB) AddAddressesToAccessList(addrs []common.Address) {
+	if added := s.accessList.AddAddresses(addrs); len(added) > 0 {
+		s.journal.append(accessListAddAccountsChange{added})
+	}
+}
+
 // AddSlotToAccessList adds the given (address, slot)-tuple to the access list
 func (s *StateDB) AddSlotToAccessList(addr common.Address, slot common.Hash) {
 	addrMod, slotMod := s.accessList.AddSlot(addr, slot)
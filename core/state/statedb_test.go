@@ -915,3 +915,49 @@ func TestStateDBAccessList(t *testing.T) {
 		t.Fatalf("expected empty, got %d", got)
 	}
 }
+
+func TestAddAddressesToAccessList(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	state.AddAddressToAccessList(common.HexToAddress("aa"))
+
+	snapshot := state.Snapshot()
+	state.AddAddressesToAccessList([]common.Address{
+		common.HexToAddress("aa"), common.HexToAddress("bb"), common.HexToAddress("bb"), common.HexToAddress("cc"),
+	})
+	for _, addr := range []string{"aa", "bb", "cc"} {
+		if !state.AddressInAccessList(common.HexToAddress(addr)) {
+			t.Fatalf("address %s missing from access list", addr)
+		}
+	}
+	// Reverting removes the batch, but not the addresses present before it
+	state.RevertToSnapshot(snapshot)
+	if !state.AddressInAccessList(common.HexToAddress("aa")) {
+		t.Fatalf("address aa removed from access list")
+	}
+	if got, exp := len(state.accessList.addresses), 1; got != exp {
+		t.Fatalf("access list size mismatch: got %d, exp %d", got, exp)
+	}
+}
+
+func BenchmarkAddAddressesToAccessList(b *testing.B) {
+	addrs := make([]common.Address, 10000)
+	for i := range addrs {
+		addrs[i] = common.BigToAddress(big.NewInt(int64(i)))
+	}
+	b.Run("single", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			for _, addr := range addrs {
+				state.AddAddressToAccessList(addr)
+			}
+		}
+	})
+	b.Run("bulk", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			state, _ := New(common.Hash{}, NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			state.AddAddressesToAccessList(addrs)
+		}
+	})
+}
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -59,6 +60,7 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	var (
 		receipts types.Receipts
 		usedGas  = new(uint64)
+		blobGas  = new(uint64)
 		header   = block.Header()
 		allLogs  []*types.Log
 		gp       = new(GasPool).AddGas(block.GasLimit())
@@ -76,7 +78,7 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 			return nil, nil, 0, err
 		}
 		statedb.Prepare(tx.Hash(), block.Hash(), i)
-		receipt, err := applyTransaction(msg, p.config, p.bc, nil, gp, statedb, header, tx, usedGas, vmenv)
+		receipt, err := applyTransaction(msg, p.config, p.bc, nil, gp, statedb, header, tx, usedGas, blobGas, vmenv)
 		if err != nil {
 			return nil, nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
 		}
@@ -89,10 +91,12 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	return receipts, allLogs, *usedGas, nil
 }
 
-func applyTransaction(msg types.Message, config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, evm *vm.EVM) (*types.Receipt, error) {
-	// Create a new context to be used in the EVM environment.
+func applyTransaction(msg types.Message, config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, blobGasUsed *uint64, evm *vm.EVM) (*types.Receipt, error) {
+	// Create a new context to be used in the EVM environment, exposing the blob
+	// gas used by the preceding transactions of the block.
 	txContext := NewEVMTxContext(msg)
 	evm.Reset(txContext, statedb)
+	evm.Context.BlobGasUsed = *blobGasUsed
 
 	// Apply the transaction to the current state (included in the env).
 	result, err := ApplyMessage(evm, msg, gp)
@@ -108,6 +112,7 @@ func applyTransaction(msg types.Message, config *params.ChainConfig, bc ChainCon
 		root = statedb.IntermediateRoot(config.IsEIP158(header.Number)).Bytes()
 	}
 	*usedGas += result.UsedGas
+	*blobGasUsed += result.BlobGasUsed
 
 	// Create a new receipt for the transaction, storing the intermediate root and gas used
 	// by the tx.
@@ -139,6 +144,13 @@ func applyTransaction(msg types.Message, config *params.ChainConfig, bc ChainCon
 // for the transaction, gas used and an error if the transaction failed,
 // indicating the block was invalid.
 func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, error) {
+	return ApplyTransactionWithBlobGas(config, bc, author, gp, statedb, header, tx, usedGas, new(uint64), cfg)
+}
+
+// ApplyTransactionWithBlobGas is like ApplyTransaction, but also tracks the blob
+// gas used by the preceding transactions of the block, which BLOBGASUSED reads.
+// Like usedGas, blobGasUsed accumulates it across the transactions.
+func ApplyTransactionWithBlobGas(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, blobGasUsed *uint64, cfg vm.Config) (*types.Receipt, error) {
 	msg, err := tx.AsMessage(types.MakeSigner(config, header.Number))
 	if err != nil {
 		return nil, err
@@ -146,5 +158,24 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	// Create a new context to be used in the EVM environment
 	blockContext := NewEVMBlockContext(header, bc, author)
 	vmenv := vm.NewEVM(blockContext, vm.TxContext{}, statedb, config, cfg)
-	return applyTransaction(msg, config, bc, author, gp, statedb, header, tx, usedGas, vmenv)
+	return applyTransaction(msg, config, bc, author, gp, statedb, header, tx, usedGas, blobGasUsed, vmenv)
+}
+
+// ProcessBeaconBlockRoot stores the parent beacon block root of the block with
+// the given timestamp in the EIP-4788 ring buffer. The timestamp is stored at
+// index timestamp % BeaconRootsHistoryBufferLength and the root the buffer
+// length after it, so contracts can look up the root of a timestamp by SLOAD.
+func ProcessBeaconBlockRoot(statedb vm.StateDB, root common.Hash, timestamp uint64) {
+	var (
+		timestampIdx = timestamp % params.BeaconRootsHistoryBufferLength
+		rootIdx      = timestampIdx + params.BeaconRootsHistoryBufferLength
+
+		timestampKey, rootKey, timestampValue common.Hash
+	)
+	binary.BigEndian.PutUint64(timestampKey[24:], timestampIdx)
+	binary.BigEndian.PutUint64(rootKey[24:], rootIdx)
+	binary.BigEndian.PutUint64(timestampValue[24:], timestamp)
+
+	statedb.SetState(params.BeaconRootsAddress, timestampKey, timestampValue)
+	statedb.SetState(params.BeaconRootsAddress, rootKey, root)
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -111,6 +112,108 @@ func TestStateProcessorErrors(t *testing.T) {
 	}
 }
 
+// TestBlobGasUsedConsistency checks that BLOBGASUSED reads the blob gas used
+// in the block alike when processing the block as a whole, as done on import,
+// and when applying its transactions one by one, as done when mining.
+func TestBlobGasUsedConsistency(t *testing.T) {
+	var (
+		signer     = types.HomesteadSigner{}
+		testKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		contract   = common.HexToAddress("0xc0de")
+		db         = rawdb.NewMemoryDatabase()
+		gspec      = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				crypto.PubkeyToAddress(testKey.PublicKey): {Balance: big.NewInt(params.Ether)},
+				// sstore(calldataload(0), blobgasused())
+				contract: {Balance: new(big.Int), Code: []byte{byte(vm.BLOBGASUSED), byte(vm.PUSH1), 0, byte(vm.CALLDATALOAD), byte(vm.SSTORE)}},
+			},
+		}
+		genesis       = gspec.MustCommit(db)
+		blockchain, _ = NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil, nil)
+		cfg           = vm.Config{BlobGasUsedOpcode: true, ExtraEips: []int{4844}}
+	)
+	defer blockchain.Stop()
+
+	var txs []*types.Transaction
+	for i := 0; i < 3; i++ {
+		slot := common.BigToHash(big.NewInt(int64(i)))
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), contract, new(big.Int), 100000, big.NewInt(1), slot.Bytes()), signer, testKey)
+		txs = append(txs, tx)
+	}
+	block := GenerateBadBlock(genesis, ethash.NewFaker(), txs)
+
+	// apply runs the transactions of the block one by one, starting with the
+	// given blob gas used by preceding transactions
+	apply := func(blobGas uint64) (*state.StateDB, types.Receipts) {
+		var (
+			statedb, _ = state.New(genesis.Root(), state.NewDatabase(db), nil)
+			gp         = new(GasPool).AddGas(block.GasLimit())
+			usedGas    uint64
+			receipts   types.Receipts
+		)
+		for i, tx := range block.Transactions() {
+			statedb.Prepare(tx.Hash(), block.Hash(), i)
+			receipt, err := ApplyTransactionWithBlobGas(gspec.Config, blockchain, &block.Header().Coinbase, gp, statedb, block.Header(), tx, &usedGas, &blobGas, cfg)
+			if err != nil {
+				t.Fatalf("tx %d: failed to apply: %v", i, err)
+			}
+			receipts = append(receipts, receipt)
+		}
+		return statedb, receipts
+	}
+	processed, _ := state.New(genesis.Root(), state.NewDatabase(db), nil)
+	receipts, _, _, err := NewStateProcessor(gspec.Config, blockchain, ethash.NewFaker()).Process(block, processed, cfg)
+	if err != nil {
+		t.Fatalf("failed to process block: %v", err)
+	}
+	applied, appliedReceipts := apply(0)
+	for i, tx := range block.Transactions() {
+		if receipts[i].Status != types.ReceiptStatusSuccessful {
+			t.Errorf("tx %d: execution failed", i)
+		}
+		if have, want := appliedReceipts[i].CumulativeGasUsed, receipts[i].CumulativeGasUsed; have != want {
+			t.Errorf("tx %d: cumulative gas mismatch: have %d, want %d", i, have, want)
+		}
+		slot := common.BytesToHash(tx.Data())
+		if have, want := applied.GetState(contract, slot), processed.GetState(contract, slot); have != want {
+			t.Errorf("tx %d: blob gas used mismatch: have %x, want %x", i, have, want)
+		}
+	}
+	// Transactions carrying blobs don't exist yet, so start from a non-zero
+	// total to check that it's passed to all transactions of the block
+	applied, _ = apply(3 * params.BlobTxBlobGasPerBlob)
+	for i, tx := range block.Transactions() {
+		want := common.BigToHash(new(big.Int).SetUint64(3 * params.BlobTxBlobGasPerBlob))
+		if have := applied.GetState(contract, common.BytesToHash(tx.Data())); have != want {
+			t.Errorf("tx %d: blob gas used mismatch: have %x, want %x", i, have, want)
+		}
+	}
+}
+
+// TestProcessBeaconBlockRoot checks that parent beacon block roots are stored
+// in the EIP-4788 ring buffer, overwriting the entries of older timestamps.
+func TestProcessBeaconBlockRoot(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	var (
+		length = params.BeaconRootsHistoryBufferLength
+		old    = common.HexToHash("0x01")
+		root   = common.HexToHash("0x02")
+	)
+	ProcessBeaconBlockRoot(statedb, old, 12)
+	ProcessBeaconBlockRoot(statedb, root, 12+length)
+
+	timestampKey := common.BigToHash(big.NewInt(12))
+	if have, want := statedb.GetState(params.BeaconRootsAddress, timestampKey), common.BigToHash(new(big.Int).SetUint64(12+length)); have != want {
+		t.Errorf("timestamp mismatch: have %x, want %x", have, want)
+	}
+	rootKey := common.BigToHash(new(big.Int).SetUint64(12 + length))
+	if have := statedb.GetState(params.BeaconRootsAddress, rootKey); have != root {
+		t.Errorf("root mismatch: have %x, want %x", have, root)
+	}
+}
+
 // GenerateBadBlock constructs a "block" which contains the transactions. The transactions are not expected to be
 // valid, and no proper post-state can be made. But from the perspective of the blockchain, the block is sufficiently
 // valid to be considered for import:
@@ -74,9 +74,10 @@ type Message interface {
 // ExecutionResult includes all output after executing given evm
 // message no matter the execution itself is successful or not.
 type ExecutionResult struct {
-	UsedGas    uint64 // Total used gas but include the refunded gas
-	Err        error  // Any error encountered during the execution(listed in core/vm/errors.go)
-	ReturnData []byte // Returned data from evm(function result or data supplied with revert opcode)
+	UsedGas     uint64 // Total used gas but include the refunded gas
+	BlobGasUsed uint64 // Blob gas consumed by the blobs of the transaction, separate from UsedGas
+	Err         error  // Any error encountered during the execution(listed in core/vm/errors.go)
+	ReturnData  []byte // Returned data from evm(function result or data supplied with revert opcode)
 }
 
 // Unwrap returns the internal evm error which allows us for further
@@ -108,12 +109,22 @@ func (result *ExecutionResult) Revert() []byte {
 
 // IntrinsicGas computes the 'intrinsic gas' for a message with the given data.
 func IntrinsicGas(data []byte, accessList types.AccessList, isContractCreation bool, isHomestead, isEIP2028 bool) (uint64, error) {
+	return intrinsicGas(params.TxGas, params.TxGasContractCreation, data, accessList, isContractCreation, isHomestead, isEIP2028)
+}
+
+// IntrinsicGasWithConfig computes the 'intrinsic gas' for a message with the
+// given data, using the base transaction costs of the given chain at block num.
+func IntrinsicGasWithConfig(config *params.ChainConfig, num *big.Int, data []byte, accessList types.AccessList, isContractCreation bool, isHomestead, isEIP2028 bool) (uint64, error) {
+	return intrinsicGas(config.TxGas(num), config.TxGasContractCreation(num), data, accessList, isContractCreation, isHomestead, isEIP2028)
+}
+
+func intrinsicGas(txGas, txGasContractCreation uint64, data []byte, accessList types.AccessList, isContractCreation bool, isHomestead, isEIP2028 bool) (uint64, error) {
 	// Set the starting gas for the raw transaction
 	var gas uint64
 	if isContractCreation && isHomestead {
-		gas = params.TxGasContractCreation
+		gas = txGasContractCreation
 	} else {
-		gas = params.TxGas
+		gas = txGas
 	}
 	// Bump the required gas by the amount of transactional data
 	if len(data) > 0 {
@@ -244,7 +255,7 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 	contractCreation := msg.To() == nil
 
 	// Check clauses 4-5, subtract intrinsic gas if everything is correct
-	gas, err := IntrinsicGas(st.data, st.msg.AccessList(), contractCreation, homestead, istanbul)
+	gas, err := IntrinsicGasWithConfig(st.evm.ChainConfig(), st.evm.Context.BlockNumber, st.data, st.msg.AccessList(), contractCreation, homestead, istanbul)
 	if err != nil {
 		return nil, err
 	}
@@ -277,15 +288,16 @@ func (st *StateTransition) TransitionDb() (*ExecutionResult, error) {
 	st.state.AddBalance(st.evm.Context.Coinbase, new(big.Int).Mul(new(big.Int).SetUint64(st.gasUsed()), st.gasPrice))
 
 	return &ExecutionResult{
-		UsedGas:    st.gasUsed(),
-		Err:        vmerr,
-		ReturnData: ret,
+		UsedGas:     st.gasUsed(),
+		BlobGasUsed: st.blobGasUsed(),
+		Err:         vmerr,
+		ReturnData:  ret,
 	}, nil
 }
 
 func (st *StateTransition) refundGas() {
-	// Apply refund counter, capped to half of the used gas.
-	refund := st.gasUsed() / 2
+	// Apply refund counter, capped to a quotient (by default half) of the used gas.
+	refund := st.gasUsed() / st.evm.ChainConfig().RefundQuotient(st.evm.Context.BlockNumber)
 	if refund > st.state.GetRefund() {
 		refund = st.state.GetRefund()
 	}
@@ -304,3 +316,9 @@ func (st *StateTransition) refundGas() {
 func (st *StateTransition) gasUsed() uint64 {
 	return st.initialGas - st.gas
 }
+
+// blobGasUsed returns the amount of blob gas consumed by the blobs carried by
+// the transaction, which is accounted separately from the execution gas.
+func (st *StateTransition) blobGasUsed() uint64 {
+	return uint64(len(st.evm.BlobHashes)) * params.BlobTxBlobGasPerBlob
+}
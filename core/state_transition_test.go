@@ -0,0 +1,162 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// TestRefundQuotient checks that the refund of a transaction is capped to the
+// configured quotient of the gas it used.
+func TestRefundQuotient(t *testing.T) {
+	var (
+		sender   = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		contract = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+
+		// Intrinsic gas, two pushes and a cold SSTORE clearing a slot
+		execGas = params.TxGas + 2*vm.GasFastestStep + params.SstoreResetGasEIP2200
+	)
+	for i, quotient := range []uint64{0, 2, 4} {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb.AddBalance(sender, big.NewInt(1000000))
+		statedb.SetCode(contract, []byte{byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.SSTORE)}) // sstore(0, 0)
+		statedb.SetState(contract, common.Hash{}, common.BytesToHash([]byte{1}))
+		statedb.Finalise(false)
+
+		config := *params.AllEthashProtocolChanges
+		config.GasCosts = &params.GasCostConfig{Block: new(big.Int), RefundQuotient: quotient}
+
+		msg := types.NewMessage(sender, &contract, 0, new(big.Int), 100000, new(big.Int), nil, nil, false)
+		vmctx := vm.BlockContext{
+			CanTransfer: CanTransfer,
+			Transfer:    Transfer,
+			BlockNumber: new(big.Int),
+		}
+		evm := vm.NewEVM(vmctx, NewEVMTxContext(msg), statedb, &config, vm.Config{})
+
+		result, err := ApplyMessage(evm, msg, new(GasPool).AddGas(100000))
+		if err != nil {
+			t.Fatalf("test %d: failed to apply message: %v", i, err)
+		}
+		if quotient == 0 {
+			quotient = params.RefundQuotient
+		}
+		refund := execGas / quotient
+		if refund > params.SstoreClearsScheduleRefundEIP2200 {
+			refund = params.SstoreClearsScheduleRefundEIP2200
+		}
+		if want := execGas - refund; result.UsedGas != want {
+			t.Errorf("test %d: gas used mismatch: have %d, want %d", i, result.UsedGas, want)
+		}
+	}
+}
+
+// TestBlobGasUsed checks that the blob gas used by a transaction is reported
+// separately from its execution gas.
+func TestBlobGasUsed(t *testing.T) {
+	var (
+		sender   = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		contract = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(sender, big.NewInt(1000000))
+
+	msg := types.NewMessage(sender, &contract, 0, new(big.Int), 100000, new(big.Int), nil, nil, false)
+	vmctx := vm.BlockContext{
+		CanTransfer: CanTransfer,
+		Transfer:    Transfer,
+		BlockNumber: new(big.Int),
+	}
+	txctx := NewEVMTxContext(msg)
+	txctx.BlobHashes = []common.Hash{{0x01}, {0x02}, {0x03}}
+	evm := vm.NewEVM(vmctx, txctx, statedb, params.AllEthashProtocolChanges, vm.Config{})
+
+	result, err := ApplyMessage(evm, msg, new(GasPool).AddGas(100000))
+	if err != nil {
+		t.Fatalf("failed to apply message: %v", err)
+	}
+	if want := 3 * params.BlobTxBlobGasPerBlob; result.BlobGasUsed != want {
+		t.Errorf("blob gas used mismatch: have %d, want %d", result.BlobGasUsed, want)
+	}
+	if result.UsedGas != params.TxGas {
+		t.Errorf("gas used mismatch: have %d, want %d", result.UsedGas, params.TxGas)
+	}
+}
+
+// TestIntrinsicGasOverride checks that the base transaction costs configured by
+// the chain are charged instead of the mainnet ones.
+func TestIntrinsicGasOverride(t *testing.T) {
+	config := *params.AllEthashProtocolChanges
+	config.GasCosts = &params.GasCostConfig{Block: big.NewInt(1), TxGas: 10000, TxGasContractCreation: 30000}
+
+	for i, test := range []struct {
+		config *params.ChainConfig
+		num    int64
+		create bool
+		want   uint64
+	}{
+		{params.AllEthashProtocolChanges, 1, false, params.TxGas},
+		{params.AllEthashProtocolChanges, 1, true, params.TxGasContractCreation},
+		{&config, 0, false, params.TxGas}, // overrides not active yet
+		{&config, 0, true, params.TxGasContractCreation},
+		{&config, 1, false, 10000},
+		{&config, 1, true, 30000},
+	} {
+		gas, err := IntrinsicGasWithConfig(test.config, big.NewInt(test.num), nil, nil, test.create, true, true)
+		if err != nil {
+			t.Fatalf("test %d: failed to compute intrinsic gas: %v", i, err)
+		}
+		if gas != test.want {
+			t.Errorf("test %d: intrinsic gas mismatch: have %d, want %d", i, gas, test.want)
+		}
+	}
+	// The chain agnostic variant keeps charging the mainnet costs
+	if gas, _ := IntrinsicGas(nil, nil, false, true, true); gas != params.TxGas {
+		t.Errorf("default intrinsic gas mismatch: have %d, want %d", gas, params.TxGas)
+	}
+	// The overridden base cost is charged when applying a message
+	var (
+		sender   = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		contract = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.AddBalance(sender, big.NewInt(1000000))
+
+	msg := types.NewMessage(sender, &contract, 0, new(big.Int), 100000, new(big.Int), nil, nil, false)
+	vmctx := vm.BlockContext{
+		CanTransfer: CanTransfer,
+		Transfer:    Transfer,
+		BlockNumber: big.NewInt(1),
+	}
+	evm := vm.NewEVM(vmctx, NewEVMTxContext(msg), statedb, &config, vm.Config{})
+
+	result, err := ApplyMessage(evm, msg, new(GasPool).AddGas(100000))
+	if err != nil {
+		t.Fatalf("failed to apply message: %v", err)
+	}
+	if result.UsedGas != 10000 {
+		t.Errorf("gas used mismatch: have %d, want %d", result.UsedGas, 10000)
+	}
+}
@@ -16,6 +16,8 @@
 
 package vm
 
+import "github.com/ethereum/go-ethereum/params"
+
 // bitvec is a bit vector which maps bytes in a program.
 // An unset bit means the byte is an opcode, a set bit means
 // it's data (i.e. argument of PUSHxx).
@@ -60,3 +62,47 @@ func codeBitmap(code []byte) bitvec {
 	}
 	return bits
 }
+
+// StackWarning reports an operation which would abort execution for finding too
+// few items on the stack.
+type StackWarning struct {
+	PC       uint64 // Position of the operation in the code
+	Op       OpCode // Operation lacking stack items
+	Height   int    // Stack items available to the operation
+	Required int    // Stack items required by the operation
+}
+
+// AnalyzeLegacyStack statically checks the straight-line code a legacy contract
+// starts with for stack underflows. Only the entry of the code has a known stack
+// height, as a JUMPDEST may be reached with any, so the analysis ends at the first
+// jump destination or terminating operation. JUMPI falls through to the next
+// operation. The opcodes of the latest fork are assumed.
+//
+// The analysis is advisory, execution of the code is unaffected.
+func AnalyzeLegacyStack(code []byte) []StackWarning {
+	var (
+		jt       = defaultJumpTable(params.Rules{IsBerlin: true})
+		warnings []StackWarning
+		height   int
+	)
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		op := OpCode(code[pc])
+		operation := jt.ops[op]
+		if op == JUMPDEST || operation == nil {
+			break
+		}
+		if height < operation.minStack {
+			warnings = append(warnings, StackWarning{PC: pc, Op: op, Height: height, Required: operation.minStack})
+			break
+		}
+		// Pushed items are the difference between the stack bounds
+		height += int(params.StackLimit) - operation.maxStack
+		if op.IsPush() {
+			pc += uint64(op - PUSH1 + 1)
+		}
+		if op == JUMP || operation.halts || operation.reverts {
+			break
+		}
+	}
+	return warnings
+}
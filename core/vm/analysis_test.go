@@ -17,6 +17,7 @@
 package vm
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/crypto"
@@ -55,6 +56,32 @@ func TestJumpDestAnalysis(t *testing.T) {
 	}
 }
 
+func TestAnalyzeLegacyStack(t *testing.T) {
+	tests := []struct {
+		code []byte
+		want []StackWarning
+	}{
+		// ADD on the empty stack
+		{[]byte{byte(ADD)}, []StackWarning{{PC: 0, Op: ADD, Height: 0, Required: 2}}},
+		// Well-formed sequence
+		{[]byte{byte(PUSH1), 1, byte(PUSH1), 2, byte(ADD), byte(PUSH1), 0, byte(MSTORE), byte(STOP)}, nil},
+		// Underflow after consuming the pushed items
+		{[]byte{byte(PUSH1), 1, byte(PUSH2), 0, 2, byte(ADD), byte(POP), byte(POP)}, []StackWarning{{PC: 7, Op: POP, Height: 0, Required: 1}}},
+		// JUMPI falls through with the remaining items
+		{[]byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(JUMPI), byte(CALLER), byte(EXTCODEHASH), byte(MUL)}, []StackWarning{{PC: 7, Op: MUL, Height: 1, Required: 2}}},
+		// Code following a JUMPDEST may be reached with any stack
+		{[]byte{byte(JUMPDEST), byte(ADD)}, nil},
+		// Code following a terminating operation is unreachable
+		{[]byte{byte(PUSH1), 0, byte(JUMP), byte(ADD)}, nil},
+		{[]byte{byte(STOP), byte(ADD)}, nil},
+	}
+	for i, test := range tests {
+		if have := AnalyzeLegacyStack(test.code); !reflect.DeepEqual(have, test.want) {
+			t.Errorf("test %d: warnings mismatch: have %+v, want %+v", i, have, test.want)
+		}
+	}
+}
+
 func BenchmarkJumpdestAnalysis_1200k(bench *testing.B) {
 	// 1.4 ms
 	code := make([]byte, 1200000)
@@ -22,6 +22,12 @@ import (
 	"github.com/holiman/uint256"
 )
 
+// balanceOf returns the balance of the given account as a uint256.
+func balanceOf(db StateDB, addr common.Address) *uint256.Int {
+	balance, _ := uint256.FromBig(db.GetBalance(addr))
+	return balance
+}
+
 // calcMemSize64 calculates the required memory size, and returns
 // the size and whether the result overflowed uint64
 func calcMemSize64(off, l *uint256.Int) (uint64, bool) {
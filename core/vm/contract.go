@@ -20,6 +20,7 @@ import (
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/holiman/uint256"
 )
 
@@ -50,8 +51,8 @@ type Contract struct {
 	caller        ContractRef
 	self          ContractRef
 
-	jumpdests map[common.Hash]bitvec // Aggregated result of JUMPDEST analysis.
-	analysis  bitvec                 // Locally cached result of JUMPDEST analysis
+	jumpdests *lru.Cache // Aggregated result of JUMPDEST analysis, nil if not shared
+	analysis  bitvec     // Locally cached result of JUMPDEST analysis
 
 	Code     []byte
 	CodeHash common.Hash
@@ -69,8 +70,6 @@ func NewContract(caller ContractRef, object ContractRef, value *big.Int, gas uin
 	if parent, ok := caller.(*Contract); ok {
 		// Reuse JUMPDEST analysis from parent context if available.
 		c.jumpdests = parent.jumpdests
-	} else {
-		c.jumpdests = make(map[common.Hash]bitvec)
 	}
 
 	// Gas should be a pointer so it can safely be reduced through the run
@@ -105,15 +104,17 @@ func (c *Contract) isCode(udest uint64) bool {
 	}
 	// Do we have a contract hash already?
 	// If we do have a hash, that means it's a 'regular' contract. For regular
-	// contracts ( not temporary initcode), we store the analysis in a map
-	if c.CodeHash != (common.Hash{}) {
+	// contracts ( not temporary initcode), we store the analysis in a cache
+	if c.CodeHash != (common.Hash{}) && c.jumpdests != nil {
 		// Does parent context have the analysis?
-		analysis, exist := c.jumpdests[c.CodeHash]
-		if !exist {
+		var analysis bitvec
+		if cached, exist := c.jumpdests.Get(c.CodeHash); exist {
+			analysis = cached.(bitvec)
+		} else {
 			// Do the analysis and save in parent context
 			// We do not need to store it in c.analysis
 			analysis = codeBitmap(c.Code)
-			c.jumpdests[c.CodeHash] = analysis
+			c.jumpdests.Add(c.CodeHash, analysis)
 		}
 		// Also stash it in current contract for faster access
 		c.analysis = analysis
@@ -142,11 +142,40 @@ func ActivePrecompiles(rules params.Rules) []common.Address {
 	}
 }
 
+// activePrecompiledContracts returns the precompiled contracts enabled with the
+// current configuration.
+func activePrecompiledContracts(rules params.Rules) map[common.Address]PrecompiledContract {
+	switch {
+	case rules.IsBerlin:
+		return PrecompiledContractsBerlin
+	case rules.IsIstanbul:
+		return PrecompiledContractsIstanbul
+	case rules.IsByzantium:
+		return PrecompiledContractsByzantium
+	default:
+		return PrecompiledContractsHomestead
+	}
+}
+
+// PrecompileGas returns the gas required to run the precompiled contract at the
+// given address on the given input, without running it. The returned flag is
+// false if there is no precompiled contract at the address.
+func PrecompileGas(addr common.Address, input []byte, rules params.Rules) (uint64, bool) {
+	p, ok := activePrecompiledContracts(rules)[addr]
+	if !ok {
+		return 0, false
+	}
+	return p.RequiredGas(input), true
+}
+
 // RunPrecompiledContract runs and evaluates the output of a precompiled contract.
 // It returns
 // - the returned bytes,
 // - the _remaining_ gas,
 // - any error that occurred
+//
+// A failing precompile never returns data, regardless of any partial output of
+// its implementation, so the caller's return data buffer is left empty.
 func RunPrecompiledContract(p PrecompiledContract, input []byte, suppliedGas uint64) (ret []byte, remainingGas uint64, err error) {
 	gasCost := p.RequiredGas(input)
 	if suppliedGas < gasCost {
@@ -154,7 +183,10 @@ func RunPrecompiledContract(p PrecompiledContract, input []byte, suppliedGas uin
 	}
 	suppliedGas -= gasCost
 	output, err := p.Run(input)
-	return output, suppliedGas, err
+	if err != nil {
+		return nil, suppliedGas, err
+	}
+	return output, suppliedGas, nil
 }
 
 // ECRECOVER implemented as a native contract.
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 // precompiledTest defines the input/output pairs for precompiled contract tests.
@@ -272,6 +273,58 @@ func TestPrecompileBlake2FMalformedInput(t *testing.T) {
 
 func TestPrecompiledEcrecover(t *testing.T) { testJson("ecRecover", "01", t) }
 
+func TestPrecompileGas(t *testing.T) {
+	modexp, err := loadJson("modexp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modexpEip2565, err := loadJson("modexp_eip2565")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		istanbul = params.Rules{IsByzantium: true, IsIstanbul: true}
+		berlin   = params.Rules{IsByzantium: true, IsIstanbul: true, IsBerlin: true}
+	)
+	for i, tt := range []struct {
+		addr  string
+		input string
+		rules params.Rules
+		gas   uint64
+		ok    bool
+	}{
+		{"01", "", berlin, params.EcrecoverGas, true},
+		{"01", modexp[0].Input, berlin, params.EcrecoverGas, true},
+		{"05", modexp[0].Input, istanbul, modexp[0].Gas, true},
+		{"05", modexpEip2565[0].Input, berlin, modexpEip2565[0].Gas, true},
+		{"0a", "", berlin, 0, false},
+	} {
+		gas, ok := PrecompileGas(common.HexToAddress(tt.addr), common.Hex2Bytes(tt.input), tt.rules)
+		if ok != tt.ok {
+			t.Errorf("test %d: precompile presence mismatch: have %v, want %v", i, ok, tt.ok)
+		}
+		if gas != tt.gas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, gas, tt.gas)
+		}
+	}
+}
+
+func TestIdentityGas(t *testing.T) {
+	for _, tt := range []struct {
+		size int
+		gas  uint64
+	}{
+		{0, 15},
+		{1, 18},
+		{32, 18},
+		{33, 21},
+	} {
+		if have := (&dataCopy{}).RequiredGas(make([]byte, tt.size)); have != tt.gas {
+			t.Errorf("%d bytes: gas mismatch: have %d, want %d", tt.size, have, tt.gas)
+		}
+	}
+}
+
 func testJson(name, addr string, t *testing.T) {
 	tests, err := loadJson(name)
 	if err != nil {
@@ -0,0 +1,101 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+// delegationPrefix is the prefix of the EIP-7702 delegation indicator, the code
+// of an account delegating to the address following the prefix.
+var delegationPrefix = []byte{0xef, 0x01, 0x00}
+
+// parseDelegation returns the address the given code delegates to, if it is a
+// delegation indicator.
+func parseDelegation(code []byte) (common.Address, bool) {
+	if len(code) != len(delegationPrefix)+common.AddressLength || !bytes.HasPrefix(code, delegationPrefix) {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(code[len(delegationPrefix):]), true
+}
+
+// resolveDelegation returns the account whose code is run for the given one,
+// which is the delegation target if its code is a delegation indicator. Only a
+// single delegation is followed: if the target delegates in turn, its own
+// delegation indicator is run, failing as invalid code.
+func resolveDelegation(db StateDB, addr common.Address) common.Address {
+	if target, ok := parseDelegation(db.GetCode(addr)); ok {
+		return target
+	}
+	return addr
+}
+
+// codeAddress returns the account whose code is run in place of the given one
+// by calls, which differs only for delegating accounts once EIP-7702 is enabled.
+// The code inspecting opcodes keep operating on the delegation indicator.
+func (evm *EVM) codeAddress(addr common.Address) common.Address {
+	if !evm.codeDelegation {
+		return addr
+	}
+	return resolveDelegation(evm.StateDB, addr)
+}
+
+// enable7702 applies EIP-7702 (Set EOA account code)
+// - Makes calls run the code of the delegation target of delegating accounts
+// - Charges calls to delegating accounts for accessing the delegation target
+func enable7702(jt *JumpTable) {
+	for _, op := range []OpCode{CALL, CALLCODE, DELEGATECALL, STATICCALL} {
+		jt.ops[op].dynamicGas = makeCallVariantGasCallEIP7702(jt.ops[op].dynamicGas)
+	}
+}
+
+// makeCallVariantGasCallEIP7702 wraps the gas function of a call variant,
+// additionally charging the warm or cold access cost of the delegation target
+// when calling a delegating account.
+func makeCallVariantGasCallEIP7702(oldCalculator gasFunc) gasFunc {
+	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		addr := common.Address(stack.Back(1).Bytes20())
+		target := resolveDelegation(evm.StateDB, addr)
+		if target == addr {
+			return oldCalculator(evm, contract, stack, mem, memorySize)
+		}
+		cost := WarmStorageReadCostEIP2929
+		if !evm.StateDB.AddressInAccessList(target) {
+			evm.StateDB.AddAddressToAccessList(target)
+			cost = ColdAccountAccessCostEIP2929
+		}
+		// Charge the access before the old calculator to correctly calculate the
+		// gas available for the call, then add it to the returned gas instead, so
+		// that it's reported to tracers as part of the dynamic gas.
+		if !contract.UseGas(cost) {
+			return 0, ErrOutOfGas
+		}
+		gas, err := oldCalculator(evm, contract, stack, mem, memorySize)
+		contract.Gas += cost
+		if err != nil {
+			return 0, err
+		}
+		var overflow bool
+		if gas, overflow = math.SafeAdd(gas, cost); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func delegationTo(addr common.Address) []byte {
+	return append(common.CopyBytes(delegationPrefix), addr.Bytes()...)
+}
+
+func TestResolveDelegation(t *testing.T) {
+	var (
+		statedb = newTestState()
+		a       = common.BytesToAddress([]byte("a"))
+		b       = common.BytesToAddress([]byte("b"))
+		c       = common.BytesToAddress([]byte("c"))
+		self    = common.BytesToAddress([]byte("self"))
+		x       = common.BytesToAddress([]byte("x"))
+		y       = common.BytesToAddress([]byte("y"))
+	)
+	statedb.SetCode(a, delegationTo(b))
+	statedb.SetCode(b, delegationTo(c))
+	statedb.SetCode(c, []byte{byte(STOP)})
+	statedb.SetCode(self, delegationTo(self))
+	statedb.SetCode(x, delegationTo(y))
+	statedb.SetCode(y, delegationTo(x))
+
+	for i, tt := range []struct {
+		addr, want common.Address
+	}{
+		{a, b},       // only the first delegation of a chain is followed
+		{c, c},       // no delegation
+		{self, self}, // self delegation
+		{x, y},       // delegation loop
+	} {
+		if have := resolveDelegation(statedb, tt.addr); have != tt.want {
+			t.Errorf("test %d: resolved address mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+}
+
+func TestDelegatedCall(t *testing.T) {
+	var (
+		statedb = newTestState()
+		eoa     = common.BytesToAddress([]byte("eoa"))
+		proxy   = common.BytesToAddress([]byte("proxy"))
+		impl    = common.BytesToAddress([]byte("impl"))
+		self    = common.BytesToAddress([]byte("self"))
+		vmctx   = testBlockContext()
+	)
+	// sstore(0, address)
+	code := []byte{byte(ADDRESS), byte(PUSH1), 0, byte(SSTORE), byte(STOP)}
+	statedb.SetCode(eoa, delegationTo(impl))
+	statedb.SetCode(proxy, delegationTo(eoa))
+	statedb.SetCode(impl, code)
+	statedb.SetCode(self, delegationTo(self))
+	statedb.AddAddressToAccessList(eoa)
+
+	evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{ExtraEips: []int{7702}})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), eoa, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("delegated call failed: %v", err)
+	}
+	// The code of the target runs in the context of the delegating account
+	if have, want := statedb.GetState(eoa, common.Hash{}), eoa.Hash(); have != want {
+		t.Errorf("storage mismatch: have %x, want %x", have, want)
+	}
+	if have := statedb.GetState(impl, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("delegation target storage modified: %x", have)
+	}
+	// A self delegation runs the delegation indicator itself, which is invalid code
+	var invalid *ErrInvalidOpCode
+	if _, _, err := evm.Call(AccountRef(common.Address{}), self, nil, 100000, new(big.Int)); !errors.As(err, &invalid) {
+		t.Errorf("self delegation error mismatch: have %v, want invalid opcode", err)
+	}
+	// So does delegating to a delegating account, chains are not followed
+	if _, _, err := evm.Call(AccountRef(common.Address{}), proxy, nil, 100000, new(big.Int)); !errors.As(err, &invalid) {
+		t.Errorf("chained delegation error mismatch: have %v, want invalid opcode", err)
+	}
+	// Without EIP-7702, the delegation indicator is run as is
+	evm = NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), eoa, nil, 100000, new(big.Int)); !errors.As(err, &invalid) {
+		t.Errorf("undelegated call error mismatch: have %v, want invalid opcode", err)
+	}
+}
+
+// TestDelegationCodeInspection checks that the code inspecting opcodes see the
+// delegation indicator of delegating accounts rather than the target's code.
+func TestDelegationCodeInspection(t *testing.T) {
+	var (
+		statedb   = newTestState()
+		eoa       = common.BytesToAddress([]byte("eoa"))
+		impl      = common.BytesToAddress([]byte("impl"))
+		indicator = delegationTo(impl)
+	)
+	statedb.SetCode(eoa, indicator)
+	statedb.SetCode(impl, []byte{byte(PUSH1), 0, byte(STOP)})
+
+	var (
+		env         = NewEVM(BlockContext{BlockNumber: new(big.Int)}, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{ExtraEips: []int{7702}})
+		interpreter = env.interpreter.(*EVMInterpreter)
+		scope       = &ScopeContext{Memory: NewMemory(), Stack: newstack()}
+		pc          = uint64(0)
+	)
+	scope.Stack.push(new(uint256.Int).SetBytes(eoa.Bytes()))
+	opExtCodeSize(&pc, interpreter, scope)
+	if have := scope.Stack.pop(); have.Uint64() != uint64(len(indicator)) {
+		t.Errorf("code size mismatch: have %d, want %d", have.Uint64(), len(indicator))
+	}
+	scope.Stack.push(new(uint256.Int).SetBytes(eoa.Bytes()))
+	opExtCodeHash(&pc, interpreter, scope)
+	hash := scope.Stack.pop()
+	if have, want := common.Hash(hash.Bytes32()), crypto.Keccak256Hash(indicator); have != want {
+		t.Errorf("code hash mismatch: have %x, want %x", have, want)
+	}
+	scope.Memory.Resize(32)
+	for _, item := range []uint64{uint64(len(indicator)), 0, 0} { // length, codeOffset, memOffset
+		scope.Stack.push(new(uint256.Int).SetUint64(item))
+	}
+	scope.Stack.push(new(uint256.Int).SetBytes(eoa.Bytes()))
+	opExtCodeCopy(&pc, interpreter, scope)
+	if have := scope.Memory.GetCopy(0, int64(len(indicator))); !bytes.Equal(have, indicator) {
+		t.Errorf("code copy mismatch: have %x, want %x", have, indicator)
+	}
+}
+
+func TestDelegatedCallGas(t *testing.T) {
+	var (
+		statedb = newTestState()
+		eoa     = common.BytesToAddress([]byte("eoa"))
+		impl    = common.BytesToAddress([]byte("impl"))
+	)
+	statedb.SetCode(eoa, delegationTo(impl))
+	statedb.SetCode(impl, []byte{byte(STOP)})
+	statedb.AddAddressToAccessList(eoa)
+
+	evm := NewEVM(BlockContext{BlockNumber: new(big.Int)}, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+	contract := NewContract(AccountRef(common.Address{}), AccountRef(common.Address{}), new(big.Int), 100000)
+	gasFn := makeCallVariantGasCallEIP7702(gasStaticCallEIP2929)
+
+	// staticcall(gas, eoa, 0, 0, 0, 0), twice to check cold and warm access to the target
+	for i, want := range []uint64{ColdAccountAccessCostEIP2929, WarmStorageReadCostEIP2929} {
+		stack := newstack()
+		for j := 0; j < 4; j++ {
+			stack.push(new(uint256.Int))
+		}
+		stack.push(new(uint256.Int).SetBytes(eoa.Bytes()))
+		stack.push(new(uint256.Int).SetUint64(1000))
+
+		gas, err := CallDynamicGas(gasFn, evm, contract, stack, NewMemory(), 0)
+		if err != nil {
+			t.Fatalf("test %d: gas calculation failed: %v", i, err)
+		}
+		// The forwarded gas is part of the dynamic gas of calls
+		if have := gas - 1000; have != want {
+			t.Errorf("test %d: delegation gas mismatch: have %d, want %d", i, have, want)
+		}
+	}
+}
@@ -18,8 +18,10 @@ package vm
 
 import (
 	"fmt"
+	"math/big"
 	"sort"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/holiman/uint256"
 )
@@ -29,24 +31,135 @@ var activators = map[int]func(*JumpTable){
 	2200: enable2200,
 	1884: enable1884,
 	1344: enable1344,
+	5656: enable5656,
+	4844: enable4844,
+	2935: enable2935,
+	7702: enable7702,
+}
+
+// eipOperations lists the operations modified or introduced by each EIP, which
+// are captured before it's enabled so that it can be rolled back.
+var eipOperations = map[int][]OpCode{
+	2929: {SSTORE, SLOAD, EXTCODECOPY, EXTCODESIZE, EXTCODEHASH, BALANCE, CALL, CALLCODE, STATICCALL, DELEGATECALL, SELFDESTRUCT},
+	2200: {SLOAD, SSTORE},
+	1884: {SLOAD, BALANCE, EXTCODEHASH, SELFBALANCE},
+	1344: {CHAINID},
+	5656: {MCOPY},
+	4844: {BLOBHASH},
+	2935: {BLOCKHASH},
+	7702: {CALL, CALLCODE, DELEGATECALL, STATICCALL},
+}
+
+// eipActivation records an EIP enabled on a jump table, along with the means
+// to roll it back.
+type eipActivation struct {
+	eip     int
+	restore func(*JumpTable)
+}
+
+// captureOperations replaces the given operations of the table with private
+// copies, which an EIP can modify without affecting the tables sharing them. It
+// returns a function restoring the prior operations, removing the ones which
+// were undefined.
+func captureOperations(jt *JumpTable, ops []OpCode) func(*JumpTable) {
+	prior := make([]*operation, len(ops))
+	for i, op := range ops {
+		if prior[i] = jt.ops[op]; prior[i] != nil {
+			copyOperation(jt, op)
+		}
+	}
+	return func(jt *JumpTable) {
+		for i, op := range ops {
+			jt.ops[op] = prior[i]
+		}
+	}
 }
 
 // EnableEIP enables the given EIP on the config.
 // This operation writes in-place, and callers need to ensure that the globally
-// defined jump tables are not polluted.
+// defined jump tables are not polluted. The operations modified by the EIP are
+// copied first, so enabling it on a copy of a global table is safe. Enabling
+// the same EIP twice on a table is rejected, as the activators are not meant to
+// be applied repeatedly.
 func EnableEIP(eipNum int, jt *JumpTable) error {
 	enablerFn, ok := activators[eipNum]
 	if !ok {
 		return fmt.Errorf("undefined eip %d", eipNum)
 	}
+	if jt.ops[STOP] == nil {
+		return fmt.Errorf("eip %d enabled on uninitialised jump table", eipNum)
+	}
+	if eipEnabled(jt, eipNum) {
+		return fmt.Errorf("eip %d already enabled", eipNum)
+	}
+	restore := captureOperations(jt, eipOperations[eipNum])
 	enablerFn(jt)
+
+	// Don't append in place, copies of the table may share the backing array
+	jt.eips = append(jt.eips[:len(jt.eips):len(jt.eips)], eipActivation{eip: eipNum, restore: restore})
+	return nil
+}
+
+// eipEnabled reports whether the given EIP was enabled on the jump table.
+func eipEnabled(jt *JumpTable, eipNum int) bool {
+	for _, activation := range jt.eips {
+		if activation.eip == eipNum {
+			return true
+		}
+	}
+	return false
+}
+
+// DisableEIP rolls back the given EIP previously enabled on the jump table,
+// restoring the operations it modified and removing the ones it introduced.
+// EIPs enabled after it are rolled back too and re-enabled afterwards, as they
+// may have modified the same operations. Like EnableEIP, this operation writes
+// in-place.
+func DisableEIP(eipNum int, jt *JumpTable) error {
+	index := -1
+	for i, activation := range jt.eips {
+		if activation.eip == eipNum {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("eip %d not enabled", eipNum)
+	}
+	later := jt.eips[index+1:]
+	for i := len(jt.eips) - 1; i >= index; i-- {
+		jt.eips[i].restore(jt)
+	}
+	jt.eips = jt.eips[:index:index]
+
+	for _, activation := range later {
+		if err := EnableEIP(activation.eip, jt); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// mustEnableEIP enables the given EIP on a jump table under construction.
+func mustEnableEIP(eipNum int, jt *JumpTable) {
+	if err := EnableEIP(eipNum, jt); err != nil {
+		panic(err)
+	}
+}
+
 func ValidEip(eipNum int) bool {
 	_, ok := activators[eipNum]
 	return ok
 }
+
+// ValidEipOrError is like ValidEip, but returns an error listing the supported
+// EIPs if the given one can't be activated.
+func ValidEipOrError(eipNum int) error {
+	if !ValidEip(eipNum) {
+		return fmt.Errorf("undefined eip %d, supported: %v", eipNum, ActivateableEips())
+	}
+	return nil
+}
 func ActivateableEips() []string {
 	var nums []string
 	for k := range activators {
@@ -63,12 +176,12 @@ func ActivateableEips() []string {
 // - Define SELFBALANCE, with cost GasFastStep (5)
 func enable1884(jt *JumpTable) {
 	// Gas cost changes
-	jt[SLOAD].constantGas = params.SloadGasEIP1884
-	jt[BALANCE].constantGas = params.BalanceGasEIP1884
-	jt[EXTCODEHASH].constantGas = params.ExtcodeHashGasEIP1884
+	jt.ops[SLOAD].constantGas = params.SloadGasEIP1884
+	jt.ops[BALANCE].constantGas = params.BalanceGasEIP1884
+	jt.ops[EXTCODEHASH].constantGas = params.ExtcodeHashGasEIP1884
 
 	// New opcode
-	jt[SELFBALANCE] = &operation{
+	jt.ops[SELFBALANCE] = &operation{
 		execute:     opSelfBalance,
 		constantGas: GasFastStep,
 		minStack:    minStack(0, 1),
@@ -77,8 +190,7 @@ func enable1884(jt *JumpTable) {
 }
 
 func opSelfBalance(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
-	balance, _ := uint256.FromBig(interpreter.evm.StateDB.GetBalance(scope.Contract.Address()))
-	scope.Stack.push(balance)
+	scope.Stack.push(balanceOf(interpreter.evm.StateDB, scope.Contract.Address()))
 	return nil, nil
 }
 
@@ -86,7 +198,7 @@ func opSelfBalance(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext)
 // - Adds an opcode that returns the current chain’s EIP-155 unique identifier
 func enable1344(jt *JumpTable) {
 	// New opcode
-	jt[CHAINID] = &operation{
+	jt.ops[CHAINID] = &operation{
 		execute:     opChainID,
 		constantGas: GasQuickStep,
 		minStack:    minStack(0, 1),
@@ -96,51 +208,176 @@ func enable1344(jt *JumpTable) {
 
 // opChainID implements CHAINID opcode
 func opChainID(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
-	chainId, _ := uint256.FromBig(interpreter.evm.chainConfig.ChainID)
+	id := interpreter.evm.chainConfig.ChainID
+	if interpreter.evm.Context.ChainID != nil {
+		id = interpreter.evm.Context.ChainID
+	}
+	chainId, _ := uint256.FromBig(id)
 	scope.Stack.push(chainId)
 	return nil, nil
 }
 
 // enable2200 applies EIP-2200 (Rebalance net-metered SSTORE)
 func enable2200(jt *JumpTable) {
-	jt[SLOAD].constantGas = params.SloadGasEIP2200
-	jt[SSTORE].dynamicGas = gasSStoreEIP2200
+	jt.ops[SLOAD].constantGas = params.SloadGasEIP2200
+	jt.ops[SSTORE].dynamicGas = gasSStoreEIP2200
 }
 
 // enable2929 enables "EIP-2929: Gas cost increases for state access opcodes"
 // https://eips.ethereum.org/EIPS/eip-2929
 func enable2929(jt *JumpTable) {
-	jt[SSTORE].dynamicGas = gasSStoreEIP2929
+	jt.ops[SSTORE].dynamicGas = gasSStoreEIP2929
 
-	jt[SLOAD].constantGas = 0
-	jt[SLOAD].dynamicGas = gasSLoadEIP2929
+	jt.ops[SLOAD].constantGas = 0
+	jt.ops[SLOAD].dynamicGas = gasSLoadEIP2929
 
-	jt[EXTCODECOPY].constantGas = WarmStorageReadCostEIP2929
-	jt[EXTCODECOPY].dynamicGas = gasExtCodeCopyEIP2929
+	jt.ops[EXTCODECOPY].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[EXTCODECOPY].dynamicGas = gasExtCodeCopyEIP2929
 
-	jt[EXTCODESIZE].constantGas = WarmStorageReadCostEIP2929
-	jt[EXTCODESIZE].dynamicGas = gasEip2929AccountCheck
+	jt.ops[EXTCODESIZE].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[EXTCODESIZE].dynamicGas = gasEip2929AccountCheck
 
-	jt[EXTCODEHASH].constantGas = WarmStorageReadCostEIP2929
-	jt[EXTCODEHASH].dynamicGas = gasEip2929AccountCheck
+	jt.ops[EXTCODEHASH].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[EXTCODEHASH].dynamicGas = gasEip2929AccountCheck
 
-	jt[BALANCE].constantGas = WarmStorageReadCostEIP2929
-	jt[BALANCE].dynamicGas = gasEip2929AccountCheck
+	jt.ops[BALANCE].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[BALANCE].dynamicGas = gasEip2929AccountCheck
 
-	jt[CALL].constantGas = WarmStorageReadCostEIP2929
-	jt[CALL].dynamicGas = gasCallEIP2929
+	jt.ops[CALL].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[CALL].dynamicGas = gasCallEIP2929
 
-	jt[CALLCODE].constantGas = WarmStorageReadCostEIP2929
-	jt[CALLCODE].dynamicGas = gasCallCodeEIP2929
+	jt.ops[CALLCODE].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[CALLCODE].dynamicGas = gasCallCodeEIP2929
 
-	jt[STATICCALL].constantGas = WarmStorageReadCostEIP2929
-	jt[STATICCALL].dynamicGas = gasStaticCallEIP2929
+	jt.ops[STATICCALL].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[STATICCALL].dynamicGas = gasStaticCallEIP2929
 
-	jt[DELEGATECALL].constantGas = WarmStorageReadCostEIP2929
-	jt[DELEGATECALL].dynamicGas = gasDelegateCallEIP2929
+	jt.ops[DELEGATECALL].constantGas = WarmStorageReadCostEIP2929
+	jt.ops[DELEGATECALL].dynamicGas = gasDelegateCallEIP2929
 
 	// This was previously part of the dynamic cost, but we're using it as a constantGas
 	// factor here
-	jt[SELFDESTRUCT].constantGas = params.SelfdestructGasEIP150
-	jt[SELFDESTRUCT].dynamicGas = gasSelfdestructEIP2929
+	jt.ops[SELFDESTRUCT].constantGas = params.SelfdestructGasEIP150
+	jt.ops[SELFDESTRUCT].dynamicGas = gasSelfdestructEIP2929
+}
+
+// enable5656 applies EIP-5656 (MCOPY opcode)
+// - Adds an opcode that copies memory, priced like the other copying opcodes
+func enable5656(jt *JumpTable) {
+	jt.ops[MCOPY] = &operation{
+		execute:     opMcopy,
+		constantGas: GasFastestStep,
+		dynamicGas:  gasMcopy,
+		minStack:    minStack(3, 0),
+		maxStack:    maxStack(3, 0),
+		memorySize:  memoryMcopy,
+	}
+}
+
+// opMcopy implements the MCOPY opcode (https://eips.ethereum.org/EIPS/eip-5656)
+func opMcopy(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		dst    = scope.Stack.pop()
+		src    = scope.Stack.pop()
+		length = scope.Stack.pop()
+	)
+	// These values are checked for overflow during memory expansion
+	scope.Memory.Copy(dst.Uint64(), src.Uint64(), length.Uint64())
+	return nil, nil
+}
+
+// enable4844 applies EIP-4844 (BLOBHASH opcode)
+// - Adds an opcode that returns the versioned hash of a blob of the transaction
+func enable4844(jt *JumpTable) {
+	jt.ops[BLOBHASH] = &operation{
+		execute:     opBlobHash,
+		constantGas: GasFastestStep,
+		minStack:    minStack(1, 1),
+		maxStack:    maxStack(1, 1),
+	}
+}
+
+// enableBlobGasUsed installs the non-standard BLOBGASUSED opcode, which isn't
+// part of any EIP and is only available if enabled by Config.BlobGasUsedOpcode
+// - Adds an opcode that returns the blob gas used so far in the current block
+func enableBlobGasUsed(jt *JumpTable) {
+	jt.ops[BLOBGASUSED] = &operation{
+		execute:     opBlobGasUsed,
+		constantGas: GasQuickStep,
+		minStack:    minStack(0, 1),
+		maxStack:    maxStack(0, 1),
+	}
+}
+
+// opBlobGasUsed implements the BLOBGASUSED opcode, pushing zero unless blobs
+// are enabled (EIP-4844).
+func opBlobGasUsed(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	used := new(uint256.Int)
+	if eipEnabled(interpreter.table, 4844) {
+		used.SetUint64(interpreter.evm.Context.BlobGasUsed)
+	}
+	scope.Stack.push(used)
+	return nil, nil
+}
+
+// opBlobHash implements the BLOBHASH opcode, pushing zero for indices outside
+// of the blobs of the transaction
+func opBlobHash(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	index := scope.Stack.peek()
+	if index.LtUint64(uint64(len(interpreter.evm.BlobHashes))) {
+		blobHash := interpreter.evm.BlobHashes[index.Uint64()]
+		index.SetBytes32(blobHash[:])
+	} else {
+		index.Clear()
+	}
+	return nil, nil
+}
+
+// enable2935 applies EIP-2935 (Serve historical block hashes from state)
+// - Makes BLOCKHASH serve the hashes of the last HistoryServeWindow blocks,
+//   reading the ones beyond the legacy 256 block window from the history contract
+func enable2935(jt *JumpTable) {
+	jt.ops[BLOCKHASH].execute = opBlockhash2935
+	jt.ops[BLOCKHASH].dynamicGas = gasBlockhash2935
+}
+
+// historyStorageSlot returns the slot of the history contract holding the hash
+// of the given block, if BLOCKHASH serves it from state rather than from the
+// legacy lookup of the most recent 256 blocks.
+func historyStorageSlot(evm *EVM, num *uint256.Int) (common.Hash, bool) {
+	num64, overflow := num.Uint64WithOverflow()
+	if overflow {
+		return common.Hash{}, false
+	}
+	upper := evm.Context.BlockNumber.Uint64()
+	if num64 >= upper || upper-num64 <= 256 || upper-num64 > params.HistoryServeWindow {
+		return common.Hash{}, false
+	}
+	return common.BigToHash(new(big.Int).SetUint64(num64 % params.HistoryServeWindow)), true
+}
+
+// gasBlockhash2935 charges the lookups BLOCKHASH serves from state like an
+// SLOAD of the history contract under EIP-2929, adding the contract and slot
+// to the access list.
+func gasBlockhash2935(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	slot, ok := historyStorageSlot(evm, stack.peek())
+	if !ok {
+		return 0, nil
+	}
+	if _, slotPresent := evm.StateDB.SlotInAccessList(params.HistoryStorageAddress, slot); !slotPresent {
+		evm.StateDB.AddSlotToAccessList(params.HistoryStorageAddress, slot)
+		return ColdSloadCostEIP2929, nil
+	}
+	return WarmStorageReadCostEIP2929, nil
+}
+
+// opBlockhash2935 implements BLOCKHASH with the extended window of EIP-2935,
+// falling back to the legacy lookup for the most recent 256 blocks.
+func opBlockhash2935(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	num := scope.Stack.peek()
+	if slot, ok := historyStorageSlot(interpreter.evm, num); ok {
+		num.SetBytes(interpreter.evm.StateDB.GetState(params.HistoryStorageAddress, slot).Bytes())
+		return nil, nil
+	}
+	return opBlockhash(pc, interpreter, scope)
 }
@@ -34,6 +34,9 @@ var (
 	ErrWriteProtection          = errors.New("write protection")
 	ErrReturnDataOutOfBounds    = errors.New("return data out of bounds")
 	ErrGasUintOverflow          = errors.New("gas uint64 overflow")
+	ErrMaxMemoryExceeded        = errors.New("max memory size exceeded")
+	ErrTotalMemoryExceeded      = errors.New("max total memory size exceeded")
+	ErrExecutionAborted         = errors.New("execution aborted")
 )
 
 // ErrStackUnderflow wraps an evm error when the items on the stack less
@@ -64,3 +67,15 @@ type ErrInvalidOpCode struct {
 }
 
 func (e *ErrInvalidOpCode) Error() string { return fmt.Sprintf("invalid opcode: %s", e.opcode) }
+
+// ErrVMPanic wraps a panic raised by an opcode implementation, recovered when
+// Config.RecoverPanics is set.
+type ErrVMPanic struct {
+	opcode OpCode
+	pc     uint64
+	reason interface{}
+}
+
+func (e *ErrVMPanic) Error() string {
+	return fmt.Sprintf("evm panic at pc=%d, op=%s: %v", e.pc, e.opcode, e.reason)
+}
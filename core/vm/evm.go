@@ -25,9 +25,14 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/holiman/uint256"
 )
 
+// jumpdestCacheSize is the number of contracts whose JUMPDEST analysis an EVM
+// keeps around for reuse.
+const jumpdestCacheSize = 1024
+
 // emptyCodeHash is used by create to ensure deployment is disallowed to already
 // deployed contract addresses (relevant after the account abstraction).
 var emptyCodeHash = crypto.Keccak256Hash(nil)
@@ -43,18 +48,7 @@ type (
 )
 
 func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
-	var precompiles map[common.Address]PrecompiledContract
-	switch {
-	case evm.chainRules.IsBerlin:
-		precompiles = PrecompiledContractsBerlin
-	case evm.chainRules.IsIstanbul:
-		precompiles = PrecompiledContractsIstanbul
-	case evm.chainRules.IsByzantium:
-		precompiles = PrecompiledContractsByzantium
-	default:
-		precompiles = PrecompiledContractsHomestead
-	}
-	p, ok := precompiles[addr]
+	p, ok := activePrecompiledContracts(evm.chainRules)[addr]
 	return p, ok
 }
 
@@ -93,14 +87,17 @@ type BlockContext struct {
 	BlockNumber *big.Int       // Provides information for NUMBER
 	Time        *big.Int       // Provides information for TIME
 	Difficulty  *big.Int       // Provides information for DIFFICULTY
+	ChainID     *big.Int       // Overrides the chain id provided for CHAINID, if set
+	BlobGasUsed uint64         // Provides information for BLOBGASUSED
 }
 
 // TxContext provides the EVM with information about a transaction.
 // All fields can change between transactions.
 type TxContext struct {
 	// Message information
-	Origin   common.Address // Provides information for ORIGIN
-	GasPrice *big.Int       // Provides information for GASPRICE
+	Origin     common.Address // Provides information for ORIGIN
+	GasPrice   *big.Int       // Provides information for GASPRICE
+	BlobHashes []common.Hash  // Provides information for BLOBHASH
 }
 
 // EVM is the Ethereum Virtual Machine base object and provides
@@ -139,11 +136,25 @@ type EVM struct {
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
 	callGasTemp uint64
+	// jumpdests caches the JUMPDEST analysis of the most recently run contracts
+	// by code hash, reused by all calls executed by this EVM, across transactions.
+	jumpdests *lru.Cache
+	// frame is the contract of the call frame being executed, nil if none.
+	frame *Contract
+	// codeDelegation is set if accounts may delegate their code (EIP-7702).
+	codeDelegation bool
+	// totalMemory is the memory allocated by all active call frames, tracked
+	// only if capped by Config.MaxTotalMemory.
+	totalMemory uint64
+	// stepAborted is set by a closed Stepper to abort the call it steps through
+	// before the paused opcode executes.
+	stepAborted bool
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
 // only ever be used *once*.
 func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig *params.ChainConfig, vmConfig Config) *EVM {
+	jumpdests, _ := lru.New(jumpdestCacheSize)
 	evm := &EVM{
 		Context:      blockCtx,
 		TxContext:    txCtx,
@@ -152,6 +163,7 @@ func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig
 		chainConfig:  chainConfig,
 		chainRules:   chainConfig.Rules(blockCtx.BlockNumber),
 		interpreters: make([]Interpreter, 0, 1),
+		jumpdests:    jumpdests,
 	}
 
 	if chainConfig.IsEWASM(blockCtx.BlockNumber) {
@@ -172,8 +184,10 @@ func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig
 
 	// vmConfig.EVMInterpreter will be used by EVM-C, it won't be checked here
 	// as we always want to have the built-in EVM as the failover option.
-	evm.interpreters = append(evm.interpreters, NewEVMInterpreter(evm, vmConfig))
+	interpreter := NewEVMInterpreter(evm, vmConfig)
+	evm.interpreters = append(evm.interpreters, interpreter)
 	evm.interpreter = evm.interpreters[0]
+	evm.codeDelegation = eipEnabled(interpreter.table, 7702)
 
 	return evm
 }
@@ -196,6 +210,33 @@ func (evm *EVM) Cancelled() bool {
 	return atomic.LoadInt32(&evm.abort) == 1
 }
 
+// captureCallSizes reports the input and output sizes of a message call to the
+// tracer, if it implements CallSizeTracer.
+func (evm *EVM) captureCallSizes(typ OpCode, from, to common.Address, depth int, input, output []byte) {
+	if tracer, ok := evm.vmConfig.Tracer.(CallSizeTracer); ok {
+		tracer.CaptureCallSizes(evm, typ, from, to, depth, len(input), len(output))
+	}
+}
+
+// Snapshot takes a snapshot of the state and of the gas left in the call frame
+// being executed, if any, allowing speculative execution to be undone with
+// Revert.
+func (evm *EVM) Snapshot() (stateID int, gas uint64) {
+	if evm.frame != nil {
+		gas = evm.frame.Gas
+	}
+	return evm.StateDB.Snapshot(), gas
+}
+
+// Revert restores the state and the gas left in the call frame being executed
+// to a snapshot taken by Snapshot.
+func (evm *EVM) Revert(stateID int, gas uint64) {
+	evm.StateDB.RevertToSnapshot(stateID)
+	if evm.frame != nil {
+		evm.frame.Gas = gas
+	}
+}
+
 // Interpreter returns the current interpreter
 func (evm *EVM) Interpreter() Interpreter {
 	return evm.interpreter
@@ -213,6 +254,9 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	if evm.vmConfig.Debug {
+		defer func(depth int) { evm.captureCallSizes(CALL, caller.Address(), addr, depth, input, ret) }(evm.depth + 1)
+	}
 	// Fail if we're trying to transfer more than the available balance
 	if value.Sign() != 0 && !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
 		return nil, gas, ErrInsufficientBalance
@@ -246,7 +290,8 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	} else {
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
-		code := evm.StateDB.GetCode(addr)
+		codeAddr := evm.codeAddress(addr)
+		code := evm.StateDB.GetCode(codeAddr)
 		if len(code) == 0 {
 			ret, err = nil, nil // gas is unchanged
 		} else {
@@ -254,7 +299,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 			// If the account has no code, we can abort here
 			// The depth-check is already done, and precompiles handled above
 			contract := NewContract(caller, AccountRef(addrCopy), value, gas)
-			contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), code)
+			contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(codeAddr), code)
 			ret, err = run(evm, contract, input, false)
 			gas = contract.Gas
 		}
@@ -289,6 +334,9 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	if evm.vmConfig.Debug {
+		defer func(depth int) { evm.captureCallSizes(CALLCODE, caller.Address(), addr, depth, input, ret) }(evm.depth + 1)
+	}
 	// Fail if we're trying to transfer more than the available balance
 	// Note although it's noop to transfer X ether to caller itself. But
 	// if caller doesn't have enough balance, it would be an error to allow
@@ -306,7 +354,8 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(caller.Address()), value, gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		codeAddr := evm.codeAddress(addrCopy)
+		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(codeAddr), evm.StateDB.GetCode(codeAddr))
 		ret, err = run(evm, contract, input, false)
 		gas = contract.Gas
 	}
@@ -332,6 +381,9 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	if evm.vmConfig.Debug {
+		defer func(depth int) { evm.captureCallSizes(DELEGATECALL, caller.Address(), addr, depth, input, ret) }(evm.depth + 1)
+	}
 	var snapshot = evm.StateDB.Snapshot()
 
 	// It is allowed to call precompiles, even via delegatecall
@@ -341,7 +393,8 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 		addrCopy := addr
 		// Initialise a new contract and make initialise the delegate values
 		contract := NewContract(caller, AccountRef(caller.Address()), nil, gas).AsDelegate()
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		codeAddr := evm.codeAddress(addrCopy)
+		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(codeAddr), evm.StateDB.GetCode(codeAddr))
 		ret, err = run(evm, contract, input, false)
 		gas = contract.Gas
 	}
@@ -366,6 +419,9 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	if evm.vmConfig.Debug {
+		defer func(depth int) { evm.captureCallSizes(STATICCALL, caller.Address(), addr, depth, input, ret) }(evm.depth + 1)
+	}
 	// We take a snapshot here. This is a bit counter-intuitive, and could probably be skipped.
 	// However, even a staticcall is considered a 'touch'. On mainnet, static calls were introduced
 	// after all empty accounts were deleted, so this is not required. However, if we omit this,
@@ -389,7 +445,8 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(addrCopy), new(big.Int), gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		codeAddr := evm.codeAddress(addrCopy)
+		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(codeAddr), evm.StateDB.GetCode(codeAddr))
 		// When an error was returned by the EVM or when setting the creation code
 		// above we revert to the snapshot and consume any gas remaining. Additionally
 		// when we're in Homestead this also counts for code storage gas errors.
@@ -418,7 +475,7 @@ func (c *codeAndHash) Hash() common.Hash {
 }
 
 // create creates a new contract using code as deployment code.
-func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64, value *big.Int, address common.Address) ([]byte, common.Address, uint64, error) {
+func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64, value *big.Int, address common.Address, typ OpCode) ([]byte, common.Address, uint64, error) {
 	// Depth check execution. Fail if we're trying to execute above the
 	// limit.
 	if evm.depth > int(params.CallCreateDepth) {
@@ -456,8 +513,13 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 		return nil, address, gas, nil
 	}
 
-	if evm.vmConfig.Debug && evm.depth == 0 {
-		evm.vmConfig.Tracer.CaptureStart(evm, caller.Address(), address, true, codeAndHash.code, gas, value)
+	if evm.vmConfig.Debug {
+		if tracer, ok := evm.vmConfig.Tracer.(CreateTracer); ok {
+			tracer.CaptureEnterCreate(evm, typ, caller.Address(), address, evm.depth+1, gas, value)
+		}
+		if evm.depth == 0 {
+			evm.vmConfig.Tracer.CaptureStart(evm, caller.Address(), address, true, codeAndHash.code, gas, value)
+		}
 	}
 	start := time.Now()
 
@@ -501,7 +563,7 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 // Create creates a new contract using code as deployment code.
 func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
 	contractAddr = crypto.CreateAddress(caller.Address(), evm.StateDB.GetNonce(caller.Address()))
-	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr)
+	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr, CREATE)
 }
 
 // Create2 creates a new contract using code as deployment code.
@@ -511,7 +573,34 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *big.Int, salt *uint256.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
 	codeAndHash := &codeAndHash{code: code}
 	contractAddr = crypto.CreateAddress2(caller.Address(), salt.Bytes32(), codeAndHash.Hash().Bytes())
-	return evm.create(caller, codeAndHash, gas, endowment, contractAddr)
+	return evm.create(caller, codeAndHash, gas, endowment, contractAddr, CREATE2)
+}
+
+// ReplayWithGasOverrides executes a message call with the constant gas of the
+// given opcodes replaced, returning the gas it used. Dynamic gas costs are left
+// as is. All state changes of the call are reverted afterwards, so the replay
+// can be used to estimate the gas of a call under a modified gas schedule.
+func (evm *EVM) ReplayWithGasOverrides(caller ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int, overrides map[OpCode]uint64) (gasUsed uint64, err error) {
+	for _, interpreter := range evm.interpreters {
+		in, ok := interpreter.(*EVMInterpreter)
+		if !ok {
+			continue
+		}
+		table := *in.table
+		for op, cost := range overrides {
+			if table.ops[op] == nil {
+				return 0, &ErrInvalidOpCode{opcode: op}
+			}
+			copyOperation(&table, op).constantGas = cost
+		}
+		defer func(table *JumpTable) { in.table = table }(in.table)
+		in.table = &table
+	}
+	snapshot := evm.StateDB.Snapshot()
+	defer evm.StateDB.RevertToSnapshot(snapshot)
+
+	_, leftOverGas, err := evm.Call(caller, addr, input, gas, value)
+	return gas - leftOverGas, err
 }
 
 // ChainConfig returns the environment's chain configuration
@@ -0,0 +1,474 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// newTestState returns an empty state backed by an in-memory database.
+func newTestState() *state.StateDB {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	return statedb
+}
+
+// testBlockContext returns the context of the genesis block, allowing and
+// ignoring all value transfers.
+func testBlockContext() BlockContext {
+	return BlockContext{
+		BlockNumber: new(big.Int),
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+	}
+}
+
+// newTestEVM returns an EVM with the given config over a fresh state, which has
+// the given code deployed at the returned address.
+func newTestEVM(code []byte, config Config) (*EVM, common.Address) {
+	var (
+		statedb = newTestState()
+		address = common.BytesToAddress([]byte("contract"))
+	)
+	statedb.SetCode(address, code)
+	return NewEVM(testBlockContext(), TxContext{}, statedb, params.AllEthashProtocolChanges, config), address
+}
+
+// jumpLoopCode counts down from 100 in a JUMPI loop, followed by a large chunk
+// of unreachable code to make the JUMPDEST analysis non-trivial.
+func jumpLoopCode() []byte {
+	code := []byte{
+		byte(PUSH1), 100,
+		byte(JUMPDEST),
+		byte(PUSH1), 1, byte(SWAP1), byte(SUB), // n = n - 1
+		byte(DUP1), byte(PUSH1), 2, byte(JUMPI), // loop while n != 0
+		byte(STOP),
+	}
+	return append(code, bytes.Repeat([]byte{byte(PUSH1), byte(JUMPDEST)}, 12000)...)
+}
+
+func TestJumpdestCache(t *testing.T) {
+	evm, address := newTestEVM(jumpLoopCode(), Config{})
+	caller := AccountRef(common.Address{})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := evm.Call(caller, address, nil, 100000, new(big.Int)); err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+		if !evm.jumpdests.Contains(evm.StateDB.GetCodeHash(address)) || evm.jumpdests.Len() != 1 {
+			t.Fatalf("call %d: analysis not cached by code hash", i)
+		}
+	}
+}
+
+// TestJumpdestCacheBounded checks that the JUMPDEST analysis cache only keeps
+// the most recently run contracts.
+func TestJumpdestCacheBounded(t *testing.T) {
+	evm, _ := newTestEVM(nil, Config{})
+	caller := AccountRef(common.Address{})
+
+	var first common.Hash
+	for i := 0; i <= jumpdestCacheSize; i++ {
+		// Distinct code per contract, jumping over the push of its index. The
+		// addresses stay clear of the precompiles.
+		address := common.BigToAddress(big.NewInt(int64(0x10000 + i)))
+		evm.StateDB.SetCode(address, []byte{byte(PUSH1), 6, byte(JUMP), byte(PUSH2), byte(i >> 8), byte(i), byte(JUMPDEST)})
+		if _, _, err := evm.Call(caller, address, nil, 100000, new(big.Int)); err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+		if i == 0 {
+			first = evm.StateDB.GetCodeHash(address)
+		}
+	}
+	if have := evm.jumpdests.Len(); have != jumpdestCacheSize {
+		t.Errorf("cache size mismatch: have %d, want %d", have, jumpdestCacheSize)
+	}
+	if evm.jumpdests.Contains(first) {
+		t.Errorf("least recently used analysis not evicted")
+	}
+}
+
+func TestJumpIntoPushData(t *testing.T) {
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 4, byte(JUMP), // jump into the data of the next push
+		byte(PUSH1), byte(JUMPDEST),
+		byte(STOP),
+	}, Config{})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != ErrInvalidJump {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrInvalidJump)
+	}
+}
+
+func BenchmarkJumpHeavyCalls(b *testing.B) {
+	evm, address := newTestEVM(jumpLoopCode(), Config{})
+	caller := AccountRef(common.Address{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := evm.Call(caller, address, nil, 100000, new(big.Int)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReplayWithGasOverrides(t *testing.T) {
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 0, byte(SLOAD), byte(POP), // sload(0)
+		byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE), // sstore(0, 1)
+		byte(STOP),
+	}, Config{})
+	evm.StateDB.AddAddressToAccessList(address)
+
+	caller := AccountRef(common.Address{})
+
+	base, err := evm.ReplayWithGasOverrides(caller, address, nil, 100000, new(big.Int), nil)
+	if err != nil {
+		t.Fatalf("baseline replay failed: %v", err)
+	}
+	// Double the (warm) cost of an SLOAD on top of its access cost
+	overrides := map[OpCode]uint64{SLOAD: 2 * WarmStorageReadCostEIP2929}
+	used, err := evm.ReplayWithGasOverrides(caller, address, nil, 100000, new(big.Int), overrides)
+	if err != nil {
+		t.Fatalf("replay with overrides failed: %v", err)
+	}
+	if want := base + 2*WarmStorageReadCostEIP2929; used != want {
+		t.Errorf("gas used mismatch: have %d, want %d", used, want)
+	}
+	// Neither the state nor the gas schedule may be affected by the replays
+	if value := evm.StateDB.GetState(address, common.Hash{}); value != (common.Hash{}) {
+		t.Errorf("replay state not reverted: slot is %x", value)
+	}
+	if used, err := evm.ReplayWithGasOverrides(caller, address, nil, 100000, new(big.Int), nil); err != nil || used != base {
+		t.Errorf("gas schedule not restored: have %d (err %v), want %d", used, err, base)
+	}
+	if _, err := evm.ReplayWithGasOverrides(caller, address, nil, 100000, new(big.Int), map[OpCode]uint64{0x0c: 1}); err == nil {
+		t.Errorf("expected overriding an undefined opcode to fail")
+	}
+}
+
+// TestSnapshotRevert checks that reverting to a snapshot taken in the middle of
+// a call undoes both the state changes and the gas spent since.
+func TestSnapshotRevert(t *testing.T) {
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 1,
+		byte(PUSH1), 0,
+		byte(SSTORE), // sstore(0, 1)
+		byte(STOP),
+	}, Config{})
+	evm.StateDB.AddAddressToAccessList(address)
+	stepper := evm.NewStepper(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+	defer stepper.Close()
+
+	// Snapshot before the SSTORE. The stepper pauses after charging the gas of
+	// the next opcode, so take it before the preceding push.
+	for stepper.PC() != 2 {
+		if done, err := stepper.Step(); done {
+			t.Fatalf("execution ended prematurely: %v", err)
+		}
+	}
+	stateID, gas := evm.Snapshot()
+
+	// Execute the SSTORE speculatively, then undo it
+	for stepper.Op() != STOP {
+		if done, err := stepper.Step(); done {
+			t.Fatalf("execution ended after SSTORE: %v", err)
+		}
+	}
+	if have := evm.StateDB.GetState(address, common.Hash{}); have != common.BigToHash(big.NewInt(1)) {
+		t.Fatalf("speculative SSTORE not executed: slot value %x", have)
+	}
+	if _, left := evm.Snapshot(); left >= gas {
+		t.Fatalf("speculative SSTORE spent no gas")
+	}
+	evm.Revert(stateID, gas)
+
+	if done, err := stepper.Step(); !done || err != nil {
+		t.Fatalf("execution not finished: %v", err)
+	}
+	if have := evm.StateDB.GetState(address, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("state not reverted: slot value %x", have)
+	}
+	if _, leftOverGas, _ := stepper.Result(); leftOverGas != gas {
+		t.Errorf("gas not reverted: have %d left, want %d", leftOverGas, gas)
+	}
+}
+
+// TestCallDepthLimit checks that all call types fail without executing the
+// callee when issued from a frame at the maximum call depth.
+func TestCallDepthLimit(t *testing.T) {
+	var (
+		caller = common.BytesToAddress([]byte("caller"))
+		callee = common.BytesToAddress([]byte("callee"))
+	)
+	for _, op := range []OpCode{CALL, CALLCODE, DELEGATECALL, STATICCALL} {
+		// Call the callee, returning the success flag
+		code := []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0}
+		if op == CALL || op == CALLCODE {
+			code = append(code, byte(PUSH1), 0) // value
+		}
+		code = append(code, byte(PUSH20))
+		code = append(code, callee.Bytes()...)
+		code = append(code, byte(GAS), byte(op),
+			byte(PUSH1), 0, byte(MSTORE),
+			byte(PUSH1), 32, byte(PUSH1), 0, byte(RETURN),
+		)
+		for _, depth := range []int{0, int(params.CallCreateDepth)} {
+			var (
+				statedb = newTestState()
+				tracer  = NewStructLogger(nil)
+			)
+			statedb.SetCode(caller, code)
+			statedb.SetCode(callee, []byte{byte(PUSH1), 1, byte(POP), byte(STOP)})
+
+			evm := NewEVM(testBlockContext(), TxContext{}, statedb, params.AllEthashProtocolChanges, Config{Debug: true, Tracer: tracer})
+			evm.depth = depth
+			ret, _, err := evm.Call(AccountRef(common.Address{}), caller, nil, 100000, new(big.Int))
+			if err != nil {
+				t.Fatalf("%v at depth %d: call failed: %v", op, depth, err)
+			}
+			limited := depth == int(params.CallCreateDepth)
+			if success := new(big.Int).SetBytes(ret).Sign() != 0; success == limited {
+				t.Errorf("%v at depth %d: success mismatch: have %v, want %v", op, depth, success, !limited)
+			}
+			executed := false
+			for _, log := range tracer.StructLogs() {
+				if log.Depth > depth+1 {
+					executed = true
+				}
+			}
+			if executed == limited {
+				t.Errorf("%v at depth %d: callee execution mismatch: have %v, want %v", op, depth, executed, !limited)
+			}
+		}
+	}
+}
+
+// TestCallInsufficientBalance checks that a CALL transferring more value than
+// the caller has fails without running the callee, only charging the caller
+// the cost of the call itself.
+func TestCallInsufficientBalance(t *testing.T) {
+	var (
+		statedb = newTestState()
+		caller  = common.BytesToAddress([]byte("caller"))
+		callee  = common.BytesToAddress([]byte("callee"))
+		vmctx   = BlockContext{
+			BlockNumber: new(big.Int),
+			CanTransfer: func(db StateDB, addr common.Address, amount *big.Int) bool {
+				return db.GetBalance(addr).Cmp(amount) >= 0
+			},
+			Transfer: func(db StateDB, from, to common.Address, amount *big.Int) {
+				db.SubBalance(from, amount)
+				db.AddBalance(to, amount)
+			},
+		}
+	)
+	// Call the callee with 1 wei, returning the success flag
+	code := []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 1, byte(PUSH20)}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(GAS), byte(CALL),
+		byte(PUSH1), 0, byte(MSTORE),
+		byte(PUSH1), 32, byte(PUSH1), 0, byte(RETURN),
+	)
+	statedb.SetCode(caller, code)
+	statedb.SetCode(callee, []byte{byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE), byte(STOP)}) // sstore(0, 1)
+
+	evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+	ret, leftOverGas, err := evm.Call(AccountRef(common.Address{}), caller, nil, 100000, new(big.Int))
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if new(big.Int).SetBytes(ret).Sign() != 0 {
+		t.Errorf("call with insufficient balance succeeded")
+	}
+	if have := statedb.GetState(callee, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("callee state modified: have %x", have)
+	}
+	if balance := statedb.GetBalance(callee); balance.Sign() != 0 {
+		t.Errorf("callee balance modified: have %v", balance)
+	}
+	// The value transfer is paid for, but none of the gas forwarded to the callee
+	used := 100000 - leftOverGas
+	if used < params.CallValueTransferGas || used >= params.CallValueTransferGas+params.SstoreSetGasEIP2200 {
+		t.Errorf("gas used mismatch: have %d, want at least %d and no callee execution", used, params.CallValueTransferGas)
+	}
+}
+
+type callSize struct {
+	typ           OpCode
+	depth         int
+	input, output int
+}
+
+// callSizeTracer records the input and output sizes of all calls.
+type callSizeTracer struct {
+	*StructLogger
+	calls []callSize
+}
+
+func (t *callSizeTracer) CaptureCallSizes(env *EVM, typ OpCode, from, to common.Address, depth int, inputSize, outputSize int) {
+	t.calls = append(t.calls, callSize{typ, depth, inputSize, outputSize})
+}
+
+func TestCallSizeTracing(t *testing.T) {
+	var (
+		statedb = newTestState()
+		caller  = common.BytesToAddress([]byte("caller"))
+		callee  = common.BytesToAddress([]byte("callee"))
+		vmctx   = testBlockContext()
+		tracer  = &callSizeTracer{StructLogger: NewStructLogger(nil)}
+	)
+	// call(gas, callee, 0, 0, 100, 0, 40)
+	code := []byte{byte(PUSH1), 40, byte(PUSH1), 0, byte(PUSH1), 100, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH20)}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(GAS), byte(CALL), byte(STOP))
+	statedb.SetCode(caller, code)
+	// return(0, 40)
+	statedb.SetCode(callee, []byte{byte(PUSH1), 40, byte(PUSH1), 0, byte(RETURN)})
+
+	evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{Debug: true, Tracer: tracer})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), caller, []byte{0x01, 0x02}, 100000, new(big.Int)); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	want := []callSize{
+		{CALL, 2, 100, 40},
+		{CALL, 1, 2, 0},
+	}
+	if !reflect.DeepEqual(tracer.calls, want) {
+		t.Errorf("call sizes mismatch: have %+v, want %+v", tracer.calls, want)
+	}
+}
+
+func TestExecuteCreation(t *testing.T) {
+	var (
+		statedb = newTestState()
+		from    = common.BytesToAddress([]byte("sender"))
+		vmctx   = testBlockContext()
+	)
+	// log0(0, 0), return(0, 1)
+	initcode := []byte{
+		byte(PUSH1), 0, byte(PUSH1), 0, byte(LOG0),
+		byte(PUSH1), 1, byte(PUSH1), 0, byte(RETURN),
+	}
+	evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+	msg := types.NewMessage(from, nil, 0, new(big.Int), 100000, new(big.Int), initcode, nil, false)
+
+	result := evm.Execute(msg)
+	if result.Err != nil {
+		t.Fatalf("creation failed: %v", result.Err)
+	}
+	if evm.StateDB != StateDB(statedb) {
+		t.Fatalf("state database not restored")
+	}
+	if len(result.Logs) != 1 {
+		t.Fatalf("log count mismatch: have %d, want 1", len(result.Logs))
+	}
+	enc, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("failed to marshal result: %v", err)
+	}
+	var dec struct {
+		GasUsed         *hexutil.Uint64 `json:"gasUsed"`
+		ContractAddress *common.Address `json:"contractAddress"`
+	}
+	if err := json.Unmarshal(enc, &dec); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if want := crypto.CreateAddress(from, 0); dec.ContractAddress == nil || *dec.ContractAddress != want {
+		t.Errorf("contract address mismatch: have %v, want %x", dec.ContractAddress, want)
+	}
+	if dec.GasUsed == nil || uint64(*dec.GasUsed) != result.GasUsed || result.GasUsed == 0 {
+		t.Errorf("gas used mismatch: have %v, want %d", dec.GasUsed, result.GasUsed)
+	}
+}
+
+func TestSimulateCall(t *testing.T) {
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE), // sstore(0, 1)
+		byte(STOP),
+	}, Config{})
+	evm.StateDB.AddAddressToAccessList(address)
+
+	_, _, diff, err := evm.SimulateCall(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+	if err != nil {
+		t.Fatalf("simulation failed: %v", err)
+	}
+	slot, want := common.Hash{}, common.BytesToHash([]byte{1})
+	if have := diff.Storage[address][slot]; have != want {
+		t.Errorf("storage diff mismatch: have %x, want %x", have, want)
+	}
+	if have := evm.StateDB.GetState(address, slot); have != (common.Hash{}) {
+		t.Errorf("simulated write persisted: have %x", have)
+	}
+	if len(diff.Balances) != 0 || len(diff.Nonces) != 0 {
+		t.Errorf("unexpected account changes: balances %v, nonces %v", diff.Balances, diff.Nonces)
+	}
+}
+
+type createEnter struct {
+	typ      OpCode
+	from, to common.Address
+	depth    int
+}
+
+// createTracer records the creations entering their initcode.
+type createTracer struct {
+	*StructLogger
+	creates []createEnter
+}
+
+func (t *createTracer) CaptureEnterCreate(env *EVM, typ OpCode, from, to common.Address, depth int, gas uint64, value *big.Int) {
+	t.creates = append(t.creates, createEnter{typ, from, to, depth})
+}
+
+func TestCreateTracing(t *testing.T) {
+	var (
+		statedb = newTestState()
+		sender  = common.BytesToAddress([]byte("sender"))
+		vmctx   = testBlockContext()
+		tracer  = &createTracer{StructLogger: NewStructLogger(nil)}
+	)
+	// The initcode deploys an empty contract itself: create(0, 0, 0)
+	initcode := []byte{byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(CREATE), byte(STOP)}
+
+	evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{Debug: true, Tracer: tracer})
+	_, deployed, _, err := evm.Create(AccountRef(sender), initcode, 100000, new(big.Int))
+	if err != nil {
+		t.Fatalf("creation failed: %v", err)
+	}
+	if want := crypto.CreateAddress(sender, 0); deployed != want {
+		t.Fatalf("deployed address mismatch: have %x, want %x", deployed, want)
+	}
+	want := []createEnter{
+		{CREATE, sender, deployed, 1},
+		{CREATE, deployed, crypto.CreateAddress(deployed, 1), 2},
+	}
+	if !reflect.DeepEqual(tracer.creates, want) {
+		t.Errorf("creations mismatch: have %+v, want %+v", tracer.creates, want)
+	}
+}
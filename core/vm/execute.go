@@ -0,0 +1,127 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Message is the message call or contract creation executed by EVM.Execute.
+type Message interface {
+	From() common.Address
+	To() *common.Address // nil for contract creation
+	Gas() uint64
+	Value() *big.Int
+	Data() []byte
+}
+
+// ExecutionResult is the outcome of a message executed by EVM.Execute, suitable
+// for logging and replaying executions.
+type ExecutionResult struct {
+	ReturnData      []byte          // Returned data, or the revert reason
+	GasUsed         uint64          // Gas used by the execution, without refunds
+	Refund          uint64          // Refund counter at the end of the execution, uncapped
+	Err             error           // Any error encountered during the execution
+	Logs            []*types.Log    // Logs emitted, excluding the ones of reverted calls
+	ContractAddress *common.Address // Address of the created contract, nil for calls
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r *ExecutionResult) MarshalJSON() ([]byte, error) {
+	type result struct {
+		ReturnData      hexutil.Bytes   `json:"returnData"`
+		GasUsed         hexutil.Uint64  `json:"gasUsed"`
+		Refund          hexutil.Uint64  `json:"refund"`
+		Err             string          `json:"error,omitempty"`
+		Logs            []*types.Log    `json:"logs"`
+		ContractAddress *common.Address `json:"contractAddress,omitempty"`
+	}
+	enc := result{
+		ReturnData:      r.ReturnData,
+		GasUsed:         hexutil.Uint64(r.GasUsed),
+		Refund:          hexutil.Uint64(r.Refund),
+		Logs:            r.Logs,
+		ContractAddress: r.ContractAddress,
+	}
+	if r.Err != nil {
+		enc.Err = r.Err.Error()
+	}
+	if enc.Logs == nil {
+		enc.Logs = []*types.Log{}
+	}
+	return json.Marshal(&enc)
+}
+
+// Execute runs the given message as a call or contract creation, collecting its
+// outcome. Unlike applying a transaction, no intrinsic gas is charged and the
+// sender's nonce and balance are left to the caller.
+func (evm *EVM) Execute(msg Message) *ExecutionResult {
+	recorder := &logRecorder{StateDB: evm.StateDB}
+	evm.StateDB = recorder
+	defer func() { evm.StateDB = recorder.StateDB }()
+
+	var (
+		result   = new(ExecutionResult)
+		caller   = AccountRef(msg.From())
+		leftOver uint64
+	)
+	if msg.To() == nil {
+		var addr common.Address
+		result.ReturnData, addr, leftOver, result.Err = evm.Create(caller, msg.Data(), msg.Gas(), msg.Value())
+		result.ContractAddress = &addr
+	} else {
+		result.ReturnData, leftOver, result.Err = evm.Call(caller, *msg.To(), msg.Data(), msg.Gas(), msg.Value())
+	}
+	result.GasUsed = msg.Gas() - leftOver
+	result.Refund = recorder.GetRefund()
+	result.Logs = recorder.logs
+	return result
+}
+
+// logRecorder is a StateDB collecting the logs added to it, dropping the ones
+// added since a snapshot when reverting to it.
+type logRecorder struct {
+	StateDB
+	logs      []*types.Log
+	snapshots map[int]int // Number of logs at the time of each snapshot
+}
+
+func (r *logRecorder) AddLog(log *types.Log) {
+	r.StateDB.AddLog(log)
+	r.logs = append(r.logs, log)
+}
+
+func (r *logRecorder) Snapshot() int {
+	id := r.StateDB.Snapshot()
+	if r.snapshots == nil {
+		r.snapshots = make(map[int]int)
+	}
+	r.snapshots[id] = len(r.logs)
+	return id
+}
+
+func (r *logRecorder) RevertToSnapshot(id int) {
+	r.StateDB.RevertToSnapshot(id)
+	if n, ok := r.snapshots[id]; ok {
+		r.logs = r.logs[:n]
+	}
+}
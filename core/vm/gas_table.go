@@ -22,6 +22,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
 )
 
 // memoryGasCost calculates the quadratic gas for memory expansion. It does so
@@ -62,6 +63,7 @@ func memoryGasCost(mem *Memory, newMemSize uint64) (uint64, error) {
 // CODECOPY (stack position 2)
 // EXTCODECOPY (stack poition 3)
 // RETURNDATACOPY (stack position 2)
+// MCOPY (stack position 2)
 func memoryCopierGas(stackpos int) gasFunc {
 	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 		// Gas for expanding the memory
@@ -91,13 +93,75 @@ var (
 	gasCodeCopy       = memoryCopierGas(2)
 	gasExtCodeCopy    = memoryCopierGas(3)
 	gasReturnDataCopy = memoryCopierGas(2)
+	gasMcopy          = memoryCopierGas(2)
 )
 
+// addSStoreRefund adds gas to the refund counter on behalf of an SSTORE to
+// the given slot according to the default refund rules, reporting the change
+// to the configured refund hook. It's a noop if the rules are replaced by a
+// custom RefundHook.
+func addSStoreRefund(evm *EVM, contract *Contract, slot *uint256.Int, gas uint64) {
+	if evm.vmConfig.RefundHook != nil {
+		return
+	}
+	changeSStoreRefund(evm, contract, slot, gas, 0)
+}
+
+// subSStoreRefund removes gas from the refund counter on behalf of an SSTORE
+// to the given slot according to the default refund rules, reporting the
+// change to the configured refund hook. It's a noop if the rules are replaced
+// by a custom RefundHook.
+func subSStoreRefund(evm *EVM, contract *Contract, slot *uint256.Int, gas uint64) {
+	if evm.vmConfig.RefundHook != nil {
+		return
+	}
+	changeSStoreRefund(evm, contract, slot, 0, gas)
+}
+
+// changeSStoreRefund adds and subtracts gas from the refund counter on behalf
+// of an SSTORE to the given slot, reporting the change to the configured
+// refund hook.
+func changeSStoreRefund(evm *EVM, contract *Contract, slot *uint256.Int, added, subtracted uint64) {
+	if added > 0 {
+		evm.StateDB.AddRefund(added)
+	}
+	if subtracted > 0 {
+		evm.StateDB.SubRefund(subtracted)
+	}
+	if hook := evm.vmConfig.SStoreRefundHook; hook != nil {
+		hook(contract.Address(), slot.Bytes32(), added, subtracted)
+	}
+}
+
+// applyRefundHook changes the refund counter by the refund the configured
+// RefundHook returns for an SSTORE of value to the given slot, if there is a
+// hook. Removals are capped at the current counter, which can't go negative.
+func applyRefundHook(evm *EVM, contract *Contract, slot *uint256.Int, current, value common.Hash) {
+	hook := evm.vmConfig.RefundHook
+	if hook == nil {
+		return
+	}
+	original := evm.StateDB.GetCommittedState(contract.Address(), slot.Bytes32())
+
+	switch refund := hook(SSTORE, original, current, value); {
+	case refund > 0:
+		changeSStoreRefund(evm, contract, slot, uint64(refund), 0)
+	case refund < 0:
+		gas := uint64(-refund)
+		if have := evm.StateDB.GetRefund(); gas > have {
+			gas = have
+		}
+		changeSStoreRefund(evm, contract, slot, 0, gas)
+	}
+}
+
 func gasSStore(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 	var (
 		y, x    = stack.Back(1), stack.Back(0)
 		current = evm.StateDB.GetState(contract.Address(), x.Bytes32())
 	)
+	applyRefundHook(evm, contract, x, current, common.Hash(y.Bytes32()))
+
 	// The legacy gas metering only takes into consideration the current state
 	// Legacy rules should be applied if we are in Petersburg (removal of EIP-1283)
 	// OR Constantinople is not active
@@ -111,7 +175,7 @@ func gasSStore(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySi
 		case current == (common.Hash{}) && y.Sign() != 0: // 0 => non 0
 			return params.SstoreSetGas, nil
 		case current != (common.Hash{}) && y.Sign() == 0: // non 0 => 0
-			evm.StateDB.AddRefund(params.SstoreRefundGas)
+			addSStoreRefund(evm, contract, x, params.SstoreRefundGas)
 			return params.SstoreClearGas, nil
 		default: // non 0 => non 0 (or 0 => 0)
 			return params.SstoreResetGas, nil
@@ -141,22 +205,22 @@ func gasSStore(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySi
 			return params.NetSstoreInitGas, nil
 		}
 		if value == (common.Hash{}) { // delete slot (2.1.2b)
-			evm.StateDB.AddRefund(params.NetSstoreClearRefund)
+			addSStoreRefund(evm, contract, x, params.NetSstoreClearRefund)
 		}
 		return params.NetSstoreCleanGas, nil // write existing slot (2.1.2)
 	}
 	if original != (common.Hash{}) {
 		if current == (common.Hash{}) { // recreate slot (2.2.1.1)
-			evm.StateDB.SubRefund(params.NetSstoreClearRefund)
+			subSStoreRefund(evm, contract, x, params.NetSstoreClearRefund)
 		} else if value == (common.Hash{}) { // delete slot (2.2.1.2)
-			evm.StateDB.AddRefund(params.NetSstoreClearRefund)
+			addSStoreRefund(evm, contract, x, params.NetSstoreClearRefund)
 		}
 	}
 	if original == value {
 		if original == (common.Hash{}) { // reset to original inexistent slot (2.2.2.1)
-			evm.StateDB.AddRefund(params.NetSstoreResetClearRefund)
+			addSStoreRefund(evm, contract, x, params.NetSstoreResetClearRefund)
 		} else { // reset to original existing slot (2.2.2.2)
-			evm.StateDB.AddRefund(params.NetSstoreResetRefund)
+			addSStoreRefund(evm, contract, x, params.NetSstoreResetRefund)
 		}
 	}
 	return params.NetSstoreDirtyGas, nil
@@ -186,6 +250,7 @@ func gasSStoreEIP2200(evm *EVM, contract *Contract, stack *Stack, mem *Memory, m
 		current = evm.StateDB.GetState(contract.Address(), x.Bytes32())
 	)
 	value := common.Hash(y.Bytes32())
+	applyRefundHook(evm, contract, x, current, value)
 
 	if current == value { // noop (1)
 		return params.SloadGasEIP2200, nil
@@ -196,22 +261,22 @@ func gasSStoreEIP2200(evm *EVM, contract *Contract, stack *Stack, mem *Memory, m
 			return params.SstoreSetGasEIP2200, nil
 		}
 		if value == (common.Hash{}) { // delete slot (2.1.2b)
-			evm.StateDB.AddRefund(params.SstoreClearsScheduleRefundEIP2200)
+			addSStoreRefund(evm, contract, x, params.SstoreClearsScheduleRefundEIP2200)
 		}
 		return params.SstoreResetGasEIP2200, nil // write existing slot (2.1.2)
 	}
 	if original != (common.Hash{}) {
 		if current == (common.Hash{}) { // recreate slot (2.2.1.1)
-			evm.StateDB.SubRefund(params.SstoreClearsScheduleRefundEIP2200)
+			subSStoreRefund(evm, contract, x, params.SstoreClearsScheduleRefundEIP2200)
 		} else if value == (common.Hash{}) { // delete slot (2.2.1.2)
-			evm.StateDB.AddRefund(params.SstoreClearsScheduleRefundEIP2200)
+			addSStoreRefund(evm, contract, x, params.SstoreClearsScheduleRefundEIP2200)
 		}
 	}
 	if original == value {
 		if original == (common.Hash{}) { // reset to original inexistent slot (2.2.2.1)
-			evm.StateDB.AddRefund(params.SstoreSetGasEIP2200 - params.SloadGasEIP2200)
+			addSStoreRefund(evm, contract, x, params.SstoreSetGasEIP2200-params.SloadGasEIP2200)
 		} else { // reset to original existing slot (2.2.2.2)
-			evm.StateDB.AddRefund(params.SstoreResetGasEIP2200 - params.SloadGasEIP2200)
+			addSStoreRefund(evm, contract, x, params.SstoreResetGasEIP2200-params.SloadGasEIP2200)
 		}
 	}
 	return params.SloadGasEIP2200, nil // dirty update (2.2)
@@ -229,15 +294,15 @@ func makeGasLog(n uint64) gasFunc {
 			return 0, err
 		}
 
-		if gas, overflow = math.SafeAdd(gas, params.LogGas); overflow {
+		if gas, overflow = math.SafeAdd(gas, evm.chainConfig.LogGas(evm.Context.BlockNumber)); overflow {
 			return 0, ErrGasUintOverflow
 		}
-		if gas, overflow = math.SafeAdd(gas, n*params.LogTopicGas); overflow {
+		if gas, overflow = math.SafeAdd(gas, n*evm.chainConfig.LogTopicGas(evm.Context.BlockNumber)); overflow {
 			return 0, ErrGasUintOverflow
 		}
 
 		var memorySizeGas uint64
-		if memorySizeGas, overflow = math.SafeMul(requestedSize, params.LogDataGas); overflow {
+		if memorySizeGas, overflow = math.SafeMul(requestedSize, evm.chainConfig.LogDataGas(evm.Context.BlockNumber)); overflow {
 			return 0, ErrGasUintOverflow
 		}
 		if gas, overflow = math.SafeAdd(gas, memorySizeGas); overflow {
@@ -256,7 +321,7 @@ func gasSha3(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize
 	if overflow {
 		return 0, ErrGasUintOverflow
 	}
-	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), params.Sha3WordGas); overflow {
+	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), evm.chainConfig.Sha3WordGas(evm.Context.BlockNumber)); overflow {
 		return 0, ErrGasUintOverflow
 	}
 	if gas, overflow = math.SafeAdd(gas, wordGas); overflow {
@@ -290,7 +355,7 @@ func gasCreate2(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memoryS
 	if overflow {
 		return 0, ErrGasUintOverflow
 	}
-	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), params.Sha3WordGas); overflow {
+	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), evm.chainConfig.Sha3WordGas(evm.Context.BlockNumber)); overflow {
 		return 0, ErrGasUintOverflow
 	}
 	if gas, overflow = math.SafeAdd(gas, wordGas); overflow {
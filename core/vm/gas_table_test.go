@@ -19,13 +19,13 @@ package vm
 import (
 	"math"
 	"math/big"
+	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/core/rawdb"
-	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
 )
 
 func TestMemoryGasCost(t *testing.T) {
@@ -81,7 +81,7 @@ func TestEIP2200(t *testing.T) {
 	for i, tt := range eip2200Tests {
 		address := common.BytesToAddress([]byte("contract"))
 
-		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedb := newTestState()
 		statedb.CreateAccount(address)
 		statedb.SetCode(address, hexutil.MustDecode(tt.input))
 		statedb.SetState(address, common.Hash{}, common.BytesToHash([]byte{tt.original}))
@@ -105,3 +105,328 @@ func TestEIP2200(t *testing.T) {
 		}
 	}
 }
+
+func TestSStoreRefundHook(t *testing.T) {
+	var (
+		address = common.BytesToAddress([]byte("contract"))
+		slot    = common.Hash{}
+		added   []uint64
+		removed []uint64
+	)
+	statedb := newTestState()
+	statedb.CreateAccount(address)
+	statedb.SetCode(address, hexutil.MustDecode("0x60006000556001600055")) // 1 -> 0 -> 1
+	statedb.SetState(address, slot, common.BytesToHash([]byte{1}))
+	statedb.Finalise(true) // Push the state into the "original" slot
+	statedb.AddAddressToAccessList(address)
+
+	hook := func(addr common.Address, key common.Hash, add, sub uint64) {
+		if addr != address || key != slot {
+			t.Errorf("unexpected refund target: %x %x", addr, key)
+		}
+		if add != 0 {
+			added = append(added, add)
+		}
+		if sub != 0 {
+			removed = append(removed, sub)
+		}
+	}
+	vmenv := NewEVM(testBlockContext(), TxContext{}, statedb, params.AllEthashProtocolChanges, Config{SStoreRefundHook: hook})
+	if _, _, err := vmenv.Call(AccountRef(common.Address{}), address, nil, math.MaxUint64, new(big.Int)); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	// Clearing adds SSTORE_CLEARS_SCHEDULE, resetting removes it again and
+	// refunds the reset cost minus the warm read.
+	if want := []uint64{params.SstoreClearsScheduleRefundEIP2200, params.SstoreResetGasEIP2200 - ColdSloadCostEIP2929 - WarmStorageReadCostEIP2929}; !reflect.DeepEqual(added, want) {
+		t.Errorf("refund additions mismatch: have %v, want %v", added, want)
+	}
+	if want := []uint64{params.SstoreClearsScheduleRefundEIP2200}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("refund subtractions mismatch: have %v, want %v", removed, want)
+	}
+	if refund := statedb.GetRefund(); refund != 2800 {
+		t.Errorf("gas refund mismatch: have %v, want %v", refund, 2800)
+	}
+}
+
+// TestSStoreRefundHookOutOfGas checks that the refund hook reports the refund
+// an SSTORE attempts even if it then runs out of gas, in which case the refund
+// counter itself is rolled back.
+func TestSStoreRefundHookOutOfGas(t *testing.T) {
+	var (
+		address = common.BytesToAddress([]byte("contract"))
+		slot    = common.Hash{}
+		added   []uint64
+	)
+	statedb := newTestState()
+	statedb.CreateAccount(address)
+	statedb.SetCode(address, hexutil.MustDecode("0x6000600055")) // 1 -> 0
+	statedb.SetState(address, slot, common.BytesToHash([]byte{1}))
+	statedb.Finalise(true)
+	statedb.AddAddressToAccessList(address)
+
+	hook := func(addr common.Address, key common.Hash, add, sub uint64) {
+		added = append(added, add)
+	}
+	vmenv := NewEVM(testBlockContext(), TxContext{}, statedb, params.AllEthashProtocolChanges, Config{SStoreRefundHook: hook})
+
+	// Enough gas to pass the EIP-2200 sentry, but not to pay for the SSTORE
+	gas := 2*GasFastestStep + params.SstoreSentryGasEIP2200 + 1
+	if _, _, err := vmenv.Call(AccountRef(common.Address{}), address, nil, gas, new(big.Int)); err != ErrOutOfGas {
+		t.Fatalf("call error mismatch: have %v, want %v", err, ErrOutOfGas)
+	}
+	if want := []uint64{params.SstoreClearsScheduleRefundEIP2200}; !reflect.DeepEqual(added, want) {
+		t.Errorf("refund additions mismatch: have %v, want %v", added, want)
+	}
+	if refund := statedb.GetRefund(); refund != 0 {
+		t.Errorf("gas refund mismatch: have %v, want 0", refund)
+	}
+}
+
+// TestCallNewAccountGas checks that a value transferring CALL pays for creating
+// the recipient if it doesn't exist yet (EIP-161), and only then.
+func TestCallNewAccountGas(t *testing.T) {
+	var (
+		fresh    = common.BytesToAddress([]byte("fresh"))
+		existing = common.BytesToAddress([]byte("existing"))
+	)
+	statedb := newTestState()
+	statedb.AddBalance(existing, big.NewInt(1))
+
+	vmctx := BlockContext{BlockNumber: new(big.Int)}
+	evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+
+	tests := []struct {
+		to    common.Address
+		value uint64
+		gas   uint64
+	}{
+		{fresh, 1, params.CallNewAccountGas + params.CallValueTransferGas},
+		{existing, 1, params.CallValueTransferGas},
+		{fresh, 0, 0},
+	}
+	for i, tt := range tests {
+		stack := newstack()
+		for _, item := range []uint64{0, 0, 0, 0, tt.value} { // retSize, retOffset, inSize, inOffset, value
+			stack.push(new(uint256.Int).SetUint64(item))
+		}
+		stack.push(new(uint256.Int).SetBytes(tt.to.Bytes()))
+		stack.push(new(uint256.Int)) // requested gas
+
+		contract := NewContract(AccountRef(common.Address{}), AccountRef(common.Address{}), new(big.Int), 100000)
+		gas, err := gasCall(evm, contract, stack, NewMemory(), 0)
+		if err != nil {
+			t.Fatalf("test %d: gas calculation failed: %v", i, err)
+		}
+		if gas != tt.gas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, gas, tt.gas)
+		}
+	}
+}
+
+// CallDynamicGas invokes a dynamic gas function in isolation. Any of evm,
+// contract, stack and mem left nil is replaced by a minimal default: a Berlin
+// EVM over an empty state, a contract with ample gas, an empty stack and an
+// empty memory respectively.
+func CallDynamicGas(fn gasFunc, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memSize uint64) (uint64, error) {
+	if evm == nil {
+		statedb := newTestState()
+		evm = NewEVM(BlockContext{BlockNumber: new(big.Int)}, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+	}
+	if contract == nil {
+		contract = NewContract(AccountRef(common.Address{}), AccountRef(common.Address{}), new(big.Int), math.MaxUint64)
+	}
+	if stack == nil {
+		stack = newstack()
+	}
+	if mem == nil {
+		mem = NewMemory()
+	}
+	return fn(evm, contract, stack, mem, memSize)
+}
+
+func TestGasSStoreEIP2929(t *testing.T) {
+	tests := []struct {
+		original, value byte
+		warm            bool
+		gas, refund     uint64
+	}{
+		{0, 0, false, ColdSloadCostEIP2929 + WarmStorageReadCostEIP2929, 0},                                         // cold no-op
+		{0, 0, true, WarmStorageReadCostEIP2929, 0},                                                                 // warm no-op
+		{0, 1, false, ColdSloadCostEIP2929 + params.SstoreSetGasEIP2200, 0},                                         // cold set
+		{0, 1, true, params.SstoreSetGasEIP2200, 0},                                                                 // warm set
+		{1, 2, false, params.SstoreResetGasEIP2200, 0},                                                              // cold reset
+		{1, 2, true, params.SstoreResetGasEIP2200 - ColdSloadCostEIP2929, 0},                                        // warm reset
+		{1, 0, false, params.SstoreResetGasEIP2200, params.SstoreClearsScheduleRefundEIP2200},                       // cold clear
+		{1, 0, true, params.SstoreResetGasEIP2200 - ColdSloadCostEIP2929, params.SstoreClearsScheduleRefundEIP2200}, // warm clear
+	}
+	for i, tt := range tests {
+		var (
+			address = common.BytesToAddress([]byte("contract"))
+			slot    = common.Hash{}
+		)
+		statedb := newTestState()
+		statedb.SetState(address, slot, common.BytesToHash([]byte{tt.original}))
+		statedb.Finalise(false) // Push the state into the "original" slot
+		statedb.AddAddressToAccessList(address)
+		if tt.warm {
+			statedb.AddSlotToAccessList(address, slot)
+		}
+		evm := NewEVM(BlockContext{BlockNumber: new(big.Int)}, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+		contract := NewContract(AccountRef(common.Address{}), AccountRef(address), new(big.Int), 100000)
+
+		stack := newstack()
+		stack.push(new(uint256.Int).SetUint64(uint64(tt.value)))
+		stack.push(new(uint256.Int).SetBytes(slot.Bytes()))
+
+		gas, err := CallDynamicGas(gasSStoreEIP2929, evm, contract, stack, nil, 0)
+		if err != nil {
+			t.Fatalf("test %d: gas calculation failed: %v", i, err)
+		}
+		if gas != tt.gas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, gas, tt.gas)
+		}
+		if refund := statedb.GetRefund(); refund != tt.refund {
+			t.Errorf("test %d: refund mismatch: have %d, want %d", i, refund, tt.refund)
+		}
+		if _, warm := statedb.SlotInAccessList(address, slot); !warm {
+			t.Errorf("test %d: slot not warmed", i)
+		}
+	}
+}
+
+// TestBulkWarmedAccountGas checks that addresses warmed in bulk are charged the
+// warm access cost, while other addresses are still charged the cold one.
+func TestBulkWarmedAccountGas(t *testing.T) {
+	statedb := newTestState()
+
+	warmed := make([]common.Address, 100)
+	for i := range warmed {
+		warmed[i] = common.BigToAddress(big.NewInt(int64(i + 1)))
+	}
+	statedb.AddAddressesToAccessList(warmed)
+
+	evm := NewEVM(BlockContext{BlockNumber: new(big.Int)}, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+	contract := NewContract(AccountRef(common.Address{}), AccountRef(common.Address{}), new(big.Int), 100000)
+
+	for i, tt := range []struct {
+		addr common.Address
+		gas  uint64
+	}{
+		{warmed[0], 0},
+		{warmed[len(warmed)-1], 0},
+		{common.BigToAddress(big.NewInt(int64(len(warmed) + 1))), ColdAccountAccessCostEIP2929 - WarmStorageReadCostEIP2929},
+	} {
+		stack := newstack()
+		stack.push(new(uint256.Int).SetBytes(tt.addr.Bytes()))
+
+		gas, err := CallDynamicGas(gasEip2929AccountCheck, evm, contract, stack, nil, 0)
+		if err != nil {
+			t.Fatalf("test %d: gas calculation failed: %v", i, err)
+		}
+		if gas != tt.gas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, gas, tt.gas)
+		}
+	}
+}
+
+// TestGasBlockhash2935 checks that BLOCKHASH lookups served from the history
+// contract are charged as cold or warm storage reads, and the others not at all.
+func TestGasBlockhash2935(t *testing.T) {
+	statedb := newTestState()
+	evm := NewEVM(BlockContext{BlockNumber: big.NewInt(10000)}, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+
+	for i, tt := range []struct {
+		num uint64
+		gas uint64
+	}{
+		{9999, 0},                                  // legacy lookup
+		{9743, ColdSloadCostEIP2929},               // first read from state
+		{9743, WarmStorageReadCostEIP2929},         // repeated read from state
+		{10000 - params.HistoryServeWindow - 1, 0}, // outside the window
+	} {
+		stack := newstack()
+		stack.push(new(uint256.Int).SetUint64(tt.num))
+
+		gas, err := CallDynamicGas(gasBlockhash2935, evm, nil, stack, nil, 0)
+		if err != nil {
+			t.Fatalf("test %d: gas calculation failed: %v", i, err)
+		}
+		if gas != tt.gas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, gas, tt.gas)
+		}
+	}
+	slot := common.BigToHash(new(big.Int).SetUint64(9743 % params.HistoryServeWindow))
+	if addrOk, slotOk := statedb.SlotInAccessList(params.HistoryStorageAddress, slot); !addrOk || !slotOk {
+		t.Errorf("history slot missing from the access list")
+	}
+}
+
+// TestSelfdestructRefund checks that SELFDESTRUCT is refunded on the forks
+// preceding EIP-3529, which removed the refund.
+func TestSelfdestructRefund(t *testing.T) {
+	istanbul := *params.AllEthashProtocolChanges
+	istanbul.BerlinBlock = nil
+
+	var (
+		address     = common.BytesToAddress([]byte("contract"))
+		beneficiary = common.BytesToAddress([]byte("beneficiary"))
+	)
+	code := append([]byte{byte(PUSH20)}, beneficiary.Bytes()...)
+	code = append(code, byte(SELFDESTRUCT))
+
+	for name, config := range map[string]*params.ChainConfig{
+		"istanbul": &istanbul,
+		"berlin":   params.AllEthashProtocolChanges,
+	} {
+		statedb := newTestState()
+		statedb.SetCode(address, code)
+		statedb.AddBalance(address, big.NewInt(1))
+		statedb.Finalise(true)
+
+		vmenv := NewEVM(testBlockContext(), TxContext{}, statedb, config, Config{})
+		if _, _, err := vmenv.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil {
+			t.Fatalf("%s: execution failed: %v", name, err)
+		}
+		if refund := statedb.GetRefund(); refund != params.SelfdestructRefundGas {
+			t.Errorf("%s: refund mismatch: have %d, want %d", name, refund, params.SelfdestructRefundGas)
+		}
+	}
+}
+
+// TestRefundHook checks that a custom refund hook replaces the default SSTORE
+// refund rules.
+func TestRefundHook(t *testing.T) {
+	var (
+		address = common.BytesToAddress([]byte("contract"))
+		slot    = common.Hash{}
+		calls   int
+	)
+	statedb := newTestState()
+	statedb.CreateAccount(address)
+	statedb.SetCode(address, hexutil.MustDecode("0x6000600055")) // 1 -> 0
+	statedb.SetState(address, slot, common.BytesToHash([]byte{1}))
+	statedb.Finalise(true) // Push the state into the "original" slot
+	statedb.AddAddressToAccessList(address)
+
+	hook := func(op OpCode, original, current, new common.Hash) int64 {
+		calls++
+		if op != SSTORE {
+			t.Errorf("unexpected opcode: %v", op)
+		}
+		one := common.BytesToHash([]byte{1})
+		if original != one || current != one || new != (common.Hash{}) {
+			t.Errorf("unexpected values: original %x, current %x, new %x", original, current, new)
+		}
+		return 1234
+	}
+	vmenv := NewEVM(testBlockContext(), TxContext{}, statedb, params.AllEthashProtocolChanges, Config{RefundHook: hook})
+	if _, _, err := vmenv.Call(AccountRef(common.Address{}), address, nil, math.MaxUint64, new(big.Int)); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("hook call count mismatch: have %d, want 1", calls)
+	}
+	if refund := statedb.GetRefund(); refund != 1234 {
+		t.Errorf("gas refund mismatch: have %v, want %v", refund, 1234)
+	}
+}
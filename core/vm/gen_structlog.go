@@ -28,6 +28,7 @@ func (s StructLog) MarshalJSON() ([]byte, error) {
 		Storage       map[common.Hash]common.Hash `json:"-"`
 		Depth         int                         `json:"depth"`
 		RefundCounter uint64                      `json:"refund"`
+		RefundDelta   int64                       `json:"refundDelta,omitempty"`
 		Err           error                       `json:"-"`
 		OpName        string                      `json:"opName"`
 		ErrorString   string                      `json:"error"`
@@ -49,6 +50,7 @@ func (s StructLog) MarshalJSON() ([]byte, error) {
 	enc.Storage = s.Storage
 	enc.Depth = s.Depth
 	enc.RefundCounter = s.RefundCounter
+	enc.RefundDelta = s.RefundDelta
 	enc.Err = s.Err
 	enc.OpName = s.OpName()
 	enc.ErrorString = s.ErrorString()
@@ -69,6 +71,7 @@ func (s *StructLog) UnmarshalJSON(input []byte) error {
 		Storage       map[common.Hash]common.Hash `json:"-"`
 		Depth         *int                        `json:"depth"`
 		RefundCounter *uint64                     `json:"refund"`
+		RefundDelta   *int64                      `json:"refundDelta,omitempty"`
 		Err           error                       `json:"-"`
 	}
 	var dec StructLog
@@ -111,6 +114,9 @@ func (s *StructLog) UnmarshalJSON(input []byte) error {
 	if dec.RefundCounter != nil {
 		s.RefundCounter = *dec.RefundCounter
 	}
+	if dec.RefundDelta != nil {
+		s.RefundDelta = *dec.RefundDelta
+	}
 	if dec.Err != nil {
 		s.Err = dec.Err
 	}
@@ -259,7 +259,7 @@ func opAddress(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]
 func opBalance(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	slot := scope.Stack.peek()
 	address := common.Address(slot.Bytes20())
-	slot.SetFromBig(interpreter.evm.StateDB.GetBalance(address))
+	slot.Set(balanceOf(interpreter.evm.StateDB, address))
 	return nil, nil
 }
 
@@ -510,6 +510,10 @@ func opSload(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]by
 	hash := common.Hash(loc.Bytes32())
 	val := interpreter.evm.StateDB.GetState(scope.Contract.Address(), hash)
 	loc.SetBytes(val.Bytes())
+	if interpreter.annotations != nil {
+		interpreter.annotations["slot"] = hash
+		interpreter.annotations["value"] = val
+	}
 	return nil, nil
 }
 
@@ -856,6 +860,14 @@ func makePush(size uint64, pushByteSize int) executionFunc {
 	}
 }
 
+// make custom context instruction function
+func makeContextOpcode(fn func(*EVM) *uint256.Int) executionFunc {
+	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+		scope.Stack.push(new(uint256.Int).Set(fn(interpreter.evm)))
+		return nil, nil
+	}
+}
+
 // make dup instruction function
 func makeDup(size int64) executionFunc {
 	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
@@ -21,9 +21,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/holiman/uint256"
@@ -537,6 +540,38 @@ func TestOpMstore(t *testing.T) {
 	}
 }
 
+func TestOpExtCodeCopyZeroFill(t *testing.T) {
+	var (
+		statedb        = newTestState()
+		env            = NewEVM(BlockContext{}, TxContext{}, statedb, params.TestChainConfig, Config{})
+		stack          = newstack()
+		mem            = NewMemory()
+		evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+		addr           = common.BytesToAddress([]byte("extcode"))
+		code           = bytes.Repeat([]byte{0xaa}, 200)
+	)
+	env.interpreter = evmInterpreter
+	statedb.SetCode(addr, code)
+
+	// Dirty the memory first, the copied tail must overwrite it with zeroes
+	mem.Resize(1024)
+	copy(mem.Data(), bytes.Repeat([]byte{0xff}, 1024))
+
+	pc := uint64(0)
+	stack.pushN(*new(uint256.Int).SetUint64(1000), *new(uint256.Int), *new(uint256.Int), *new(uint256.Int).SetBytes(addr.Bytes()))
+	opExtCodeCopy(&pc, evmInterpreter, &ScopeContext{mem, stack, nil})
+
+	if got := mem.GetCopy(0, 200); !bytes.Equal(got, code) {
+		t.Fatalf("code mismatch: have %x, want %x", got, code)
+	}
+	if got := mem.GetCopy(200, 800); !bytes.Equal(got, make([]byte, 800)) {
+		t.Fatalf("tail not zero-filled: %x", got)
+	}
+	if got := mem.GetCopy(1000, 24); !bytes.Equal(got, bytes.Repeat([]byte{0xff}, 24)) {
+		t.Fatalf("memory beyond copy modified: %x", got)
+	}
+}
+
 func BenchmarkOpMstore(bench *testing.B) {
 	var (
 		env            = NewEVM(BlockContext{}, TxContext{}, nil, params.TestChainConfig, Config{})
@@ -650,3 +685,351 @@ func TestCreate2Addreses(t *testing.T) {
 		}
 	}
 }
+
+// TestOpPush1 checks that the specialised PUSH1 handler matches the generic
+// push implementation for every immediate value, and for truncated code.
+func TestOpPush1(t *testing.T) {
+	var (
+		env         = NewEVM(BlockContext{}, TxContext{}, nil, params.TestChainConfig, Config{})
+		interpreter = NewEVMInterpreter(env, env.vmConfig)
+		generic     = makePush(1, 1)
+	)
+	run := func(op executionFunc, code []byte) (uint256.Int, uint64) {
+		contract := NewContract(AccountRef(common.Address{}), AccountRef(common.Address{}), new(big.Int), 0)
+		contract.Code = code
+
+		stack, pc := newstack(), uint64(0)
+		op(&pc, interpreter, &ScopeContext{nil, stack, contract})
+		return stack.pop(), pc
+	}
+	for i := 0; i < 256; i++ {
+		code := []byte{byte(PUSH1), byte(i)}
+
+		have, havePc := run(opPush1, code)
+		want, wantPc := run(generic, code)
+		if have != want || have.Uint64() != uint64(i) {
+			t.Errorf("value %d: pushed %v, want %v", i, have.Hex(), want.Hex())
+		}
+		if havePc != wantPc {
+			t.Errorf("value %d: pc %d, want %d", i, havePc, wantPc)
+		}
+	}
+	// PUSH1 as the last byte of the code pushes zero
+	if have, _ := run(opPush1, []byte{byte(PUSH1)}); !have.IsZero() {
+		t.Errorf("truncated push: pushed %v, want 0", have.Hex())
+	}
+}
+
+func benchmarkPush1(b *testing.B, op executionFunc) {
+	var (
+		env         = NewEVM(BlockContext{}, TxContext{}, nil, params.TestChainConfig, Config{})
+		interpreter = NewEVMInterpreter(env, env.vmConfig)
+		stack       = newstack()
+		contract    = NewContract(AccountRef(common.Address{}), AccountRef(common.Address{}), new(big.Int), 0)
+		scope       = &ScopeContext{nil, stack, contract}
+	)
+	contract.Code = []byte{byte(PUSH1), 0xff}
+
+	pc := uint64(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pc = 0
+		op(&pc, interpreter, scope)
+		stack.pop()
+	}
+}
+
+func BenchmarkOpPush1(b *testing.B)        { benchmarkPush1(b, opPush1) }
+func BenchmarkOpPush1Generic(b *testing.B) { benchmarkPush1(b, makePush(1, 1)) }
+
+func mustUint256(hex string) *uint256.Int {
+	v, _ := uint256.FromBig(math.MustParseBig256(hex))
+	return v
+}
+
+func TestOpMCopy(t *testing.T) {
+	// Test cases from https://eips.ethereum.org/EIPS/eip-5656#test-cases
+	for i, tc := range []struct {
+		dst, src, len string
+		pre           string
+		want          string
+		wantGas       uint64
+	}{
+		{ // MCOPY 0 32 32 - copy 32 bytes from offset 32 to offset 0.
+			dst: "0x0", src: "0x20", len: "0x20",
+			pre:     "0000000000000000000000000000000000000000000000000000000000000000 000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			want:    "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f 000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			wantGas: 6,
+		},
+		{ // MCOPY 0 0 32 - copy 32 bytes from offset 0 to offset 0.
+			dst: "0x0", src: "0x0", len: "0x20",
+			pre:     "0101010101010101010101010101010101010101010101010101010101010101",
+			want:    "0101010101010101010101010101010101010101010101010101010101010101",
+			wantGas: 6,
+		},
+		{ // MCOPY 0 1 8 - copy 8 bytes from offset 1 to offset 0 (overlapping).
+			dst: "0x0", src: "0x1", len: "0x8",
+			pre:     "000102030405060708 000000000000000000000000000000000000000000000000",
+			want:    "010203040506070808 000000000000000000000000000000000000000000000000",
+			wantGas: 6,
+		},
+		{ // MCOPY 1 0 8 - copy 8 bytes from offset 0 to offset 1 (overlapping).
+			dst: "0x1", src: "0x0", len: "0x8",
+			pre:     "000102030405060708 000000000000000000000000000000000000000000000000",
+			want:    "000001020304050607 000000000000000000000000000000000000000000000000",
+			wantGas: 6,
+		},
+		{ // MCOPY 0xFFFFFFFFFFFF 0xFFFFFFFFFFFF 0 - zero length at large offsets.
+			dst: "0xFFFFFFFFFFFF", src: "0xFFFFFFFFFFFF", len: "0x0",
+			wantGas: 3,
+		},
+		{ // MCOPY 0xFFFFFFFFFFFFFFFFFFFF 0 0 - zero length at an overflowing offset.
+			dst: "0xFFFFFFFFFFFFFFFFFFFF", src: "0x0", len: "0x0",
+			pre:     "11",
+			want:    "11",
+			wantGas: 3,
+		},
+		{ // MCOPY 0x20 0 32 - expanding memory to fit the destination.
+			dst: "0x20", src: "0x0", len: "0x20",
+			pre:     "0101010101010101010101010101010101010101010101010101010101010101",
+			want:    "0101010101010101010101010101010101010101010101010101010101010101 0101010101010101010101010101010101010101010101010101010101010101",
+			wantGas: 12,
+		},
+		{ // MCOPY 0 0x20 32 - expanding memory to fit the source, copying zeroes.
+			dst: "0x0", src: "0x20", len: "0x20",
+			pre:     "0101010101010101010101010101010101010101010101010101010101010101",
+			want:    "0000000000000000000000000000000000000000000000000000000000000000 0000000000000000000000000000000000000000000000000000000000000000",
+			wantGas: 12,
+		},
+	} {
+		var (
+			env            = NewEVM(BlockContext{}, TxContext{}, nil, params.TestChainConfig, Config{})
+			stack          = newstack()
+			pc             = uint64(0)
+			evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+		)
+		data := common.FromHex(strings.ReplaceAll(tc.pre, " ", ""))
+		// Set pre
+		mem := NewMemory()
+		mem.Resize(uint64(len(data)))
+		mem.Set(0, uint64(len(data)), data)
+		// Push stack args
+		stack.push(mustUint256(tc.len))
+		stack.push(mustUint256(tc.src))
+		stack.push(mustUint256(tc.dst))
+
+		// Calculate the memory expansion like the interpreter does
+		var memorySize uint64
+		memSize, overflow := memoryMcopy(stack)
+		if overflow {
+			t.Fatalf("test %d: memory size overflow", i)
+		}
+		if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
+			t.Fatalf("test %d: memory size overflow", i)
+		}
+		dynamicCost, err := gasMcopy(env, nil, stack, mem, memorySize)
+		if err != nil {
+			t.Fatalf("test %d: failed to calculate gas: %v", i, err)
+		}
+		if have := GasFastestStep + dynamicCost; have != tc.wantGas {
+			t.Errorf("test %d: gas mismatch: have %d, want %d", i, have, tc.wantGas)
+		}
+		if memorySize > 0 {
+			mem.Resize(memorySize)
+		}
+		opMcopy(&pc, evmInterpreter, &ScopeContext{mem, stack, nil})
+		want := common.FromHex(strings.ReplaceAll(tc.want, " ", ""))
+		if have := mem.store; !bytes.Equal(want, have) {
+			t.Errorf("test %d: memory mismatch\nhave: %x\nwant: %x", i, have, want)
+		}
+	}
+}
+
+// TestMCopyOverflow checks that MCOPY ranges overflowing uint64 are rejected
+// rather than wrapping around.
+func TestMCopyOverflow(t *testing.T) {
+	for i, args := range [][3]string{
+		{"0x0", "0x0", "0x10000000000000000"},
+		{"0xFFFFFFFFFFFFFFFF", "0x0", "0x1"},
+		{"0x0", "0xFFFFFFFFFFFFFFFF", "0x1"},
+	} {
+		stack := newstack()
+		stack.push(mustUint256(args[2]))
+		stack.push(mustUint256(args[1]))
+		stack.push(mustUint256(args[0]))
+		if _, overflow := memoryMcopy(stack); !overflow {
+			t.Errorf("test %d: overflow not detected", i)
+		}
+	}
+}
+
+func TestOpBlobHash(t *testing.T) {
+	hashes := []common.Hash{{0x01, 0x01}, {0x01, 0x02}, {0x01, 0x03}}
+	for i, tc := range []struct {
+		index  string
+		hashes []common.Hash
+		want   common.Hash
+	}{
+		{"0x0", hashes, hashes[0]},
+		{"0x2", hashes, hashes[2]},
+		{"0x3", hashes, common.Hash{}},
+		{"0x10000000000000000", hashes, common.Hash{}},
+		{"0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", hashes, common.Hash{}},
+		{"0x0", nil, common.Hash{}},
+	} {
+		var (
+			env            = NewEVM(BlockContext{}, TxContext{BlobHashes: tc.hashes}, nil, params.TestChainConfig, Config{})
+			stack          = newstack()
+			pc             = uint64(0)
+			evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+		)
+		stack.push(mustUint256(tc.index))
+		opBlobHash(&pc, evmInterpreter, &ScopeContext{nil, stack, nil})
+		result := stack.pop()
+		if have := common.Hash(result.Bytes32()); have != tc.want {
+			t.Errorf("test %d: hash mismatch: have %x, want %x", i, have, tc.want)
+		}
+	}
+}
+
+func TestOpBalance(t *testing.T) {
+	var (
+		statedb        = newTestState()
+		address        = common.BytesToAddress([]byte("contract"))
+		balance, _     = new(big.Int).SetString("0x1234567890abcdef1234567890abcdef", 0)
+		env            = NewEVM(BlockContext{}, TxContext{}, statedb, params.TestChainConfig, Config{})
+		stack          = newstack()
+		pc             = uint64(0)
+		evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+		scope          = &ScopeContext{nil, stack, NewContract(AccountRef(common.Address{}), AccountRef(address), new(big.Int), 0)}
+	)
+	statedb.AddBalance(address, balance)
+
+	stack.push(new(uint256.Int).SetBytes(address.Bytes()))
+	opBalance(&pc, evmInterpreter, scope)
+	opSelfBalance(&pc, evmInterpreter, scope)
+	for _, op := range []OpCode{SELFBALANCE, BALANCE} {
+		if have := stack.pop(); have.ToBig().Cmp(balance) != 0 {
+			t.Errorf("%v: balance mismatch: have %v, want %v", op, have.ToBig(), balance)
+		}
+	}
+}
+
+func TestOpBlockhash2935(t *testing.T) {
+	var (
+		statedb = newTestState()
+		current = uint64(10000)
+		window  = params.HistoryServeWindow
+
+		// Legacy and stored hashes differ, to tell which lookup was used
+		legacy = func(n uint64) common.Hash { return common.BigToHash(new(big.Int).SetUint64(n)) }
+		stored = func(n uint64) common.Hash { return common.BigToHash(new(big.Int).SetUint64(n + 1<<32)) }
+	)
+	for n := current - window; n < current; n++ {
+		statedb.SetState(params.HistoryStorageAddress, legacy(n%window), stored(n))
+	}
+	vmctx := BlockContext{
+		BlockNumber: new(big.Int).SetUint64(current),
+		GetHash:     legacy,
+	}
+	for i, tc := range []struct {
+		num  uint64
+		want common.Hash
+	}{
+		{current, common.Hash{}},
+		{current - 1, legacy(current - 1)},
+		{current - 256, legacy(current - 256)},
+		{current - 257, stored(current - 257)},
+		{current - window, stored(current - window)}, // just inside the window
+		{current - window - 1, common.Hash{}},        // just outside the window
+	} {
+		var (
+			env            = NewEVM(vmctx, TxContext{}, statedb, params.TestChainConfig, Config{})
+			stack          = newstack()
+			pc             = uint64(0)
+			evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+		)
+		stack.push(new(uint256.Int).SetUint64(tc.num))
+		opBlockhash2935(&pc, evmInterpreter, &ScopeContext{nil, stack, nil})
+		result := stack.pop()
+		if have := common.Hash(result.Bytes32()); have != tc.want {
+			t.Errorf("test %d: hash mismatch: have %x, want %x", i, have, tc.want)
+		}
+	}
+}
+
+func TestOpChainIDOverride(t *testing.T) {
+	for i, tc := range []struct {
+		override *big.Int
+		want     *big.Int
+	}{
+		{nil, params.TestChainConfig.ChainID},
+		{big.NewInt(5), big.NewInt(5)},
+	} {
+		var (
+			env            = NewEVM(BlockContext{ChainID: tc.override}, TxContext{}, nil, params.TestChainConfig, Config{})
+			stack          = newstack()
+			pc             = uint64(0)
+			evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+		)
+		opChainID(&pc, evmInterpreter, &ScopeContext{nil, stack, nil})
+		if have := stack.pop(); have.ToBig().Cmp(tc.want) != 0 {
+			t.Errorf("test %d: chain id mismatch: have %v, want %v", i, have.ToBig(), tc.want)
+		}
+	}
+}
+
+// TestPushPastCodeEnd checks that a PUSH whose immediate extends past the end of
+// the code is zero-padded, and that execution then halts.
+func TestPushPastCodeEnd(t *testing.T) {
+	for i, tc := range []struct {
+		code []byte
+		pc   uint64 // position of the PUSH32
+		want string
+	}{
+		{[]byte{byte(PUSH32)}, 0, "0x0"},
+		{[]byte{byte(PUSH32), 0x01, 0x02}, 0, "0x102000000000000000000000000000000000000000000000000000000000000"},
+		{[]byte{byte(PUSH1), 0x01, byte(PUSH32), 0xff}, 2, "0xff00000000000000000000000000000000000000000000000000000000000000"},
+	} {
+		var (
+			env            = NewEVM(BlockContext{}, TxContext{}, nil, params.TestChainConfig, Config{})
+			stack          = newstack()
+			pc             = tc.pc
+			evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+			contract       = NewContract(AccountRef(common.Address{}), AccountRef(common.Address{}), new(big.Int), 0)
+		)
+		contract.Code = tc.code
+		makePush(32, 32)(&pc, evmInterpreter, &ScopeContext{nil, stack, contract})
+		if have := stack.pop(); have.Hex() != tc.want {
+			t.Errorf("test %d: pushed value mismatch: have %s, want %s", i, have.Hex(), tc.want)
+		}
+		// Running past the end of the code is an implicit STOP
+		evm, address := newTestEVM(tc.code, Config{})
+		if ret, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil || len(ret) != 0 {
+			t.Errorf("test %d: execution did not halt: ret %x, err %v", i, ret, err)
+		}
+	}
+}
+
+func TestOpBlobGasUsed(t *testing.T) {
+	for i, tc := range []struct {
+		eips []int
+		want uint64
+	}{
+		{[]int{4844}, 3 * params.BlobTxBlobGasPerBlob},
+		{nil, 0}, // blobs disabled
+	} {
+		var (
+			vmctx          = BlockContext{BlobGasUsed: 3 * params.BlobTxBlobGasPerBlob}
+			env            = NewEVM(vmctx, TxContext{}, nil, params.TestChainConfig, Config{ExtraEips: tc.eips, BlobGasUsedOpcode: true})
+			stack          = newstack()
+			pc             = uint64(0)
+			evmInterpreter = NewEVMInterpreter(env, env.vmConfig)
+		)
+		opBlobGasUsed(&pc, evmInterpreter, &ScopeContext{nil, stack, nil})
+		if have := stack.pop(); have.Uint64() != tc.want {
+			t.Errorf("test %d: blob gas used mismatch: have %d, want %d", i, have.Uint64(), tc.want)
+		}
+	}
+}
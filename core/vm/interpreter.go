@@ -18,11 +18,13 @@ package vm
 
 import (
 	"hash"
+	"runtime/debug"
 	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/holiman/uint256"
 )
 
 // Config are the configuration options for the Interpreter
@@ -31,6 +33,13 @@ type Config struct {
 	Tracer                  Tracer // Opcode logger
 	NoRecursion             bool   // Disables call, callcode, delegate call and create
 	EnablePreimageRecording bool   // Enables recording of SHA3/keccak preimages
+	RecoverPanics           bool   // Converts panics during opcode execution into ErrVMPanic
+	OpcodeMetrics           bool   // Counts executed opcodes in the "vm/opcodes" metrics, added when the outermost call returns
+	OpcodeStats             bool   // Counts executed opcodes per interpreter, see EVMInterpreter.OpcodeStats
+	GasBreakdown            bool   // Accumulates gas spent per opcode, see EVMInterpreter.GasBreakdown
+	MaxMemorySize           uint64 // Caps the memory of a single call frame (0 = unlimited)
+	MaxTotalMemory          uint64 // Caps the memory of all active call frames combined (0 = unlimited)
+	BlobGasUsedOpcode       bool   // Enables the non-standard BLOBGASUSED opcode
 
 	JumpTable [256]*operation // EVM instruction table, automatically populated if unset
 
@@ -38,6 +47,26 @@ type Config struct {
 	EVMInterpreter   string // External EVM interpreter options
 
 	ExtraEips []int // Additional EIPS that are to be enabled
+
+	// SStoreRefundHook, if set, is invoked for every change an SSTORE makes to
+	// the refund counter, with the gas added to or subtracted from it. Changes
+	// are reported as they are attempted while calculating the gas of the
+	// SSTORE: they are rolled back without notice if the SSTORE runs out of gas
+	// or an enclosing call reverts. If RefundHook is set, the changes it makes
+	// are reported instead of the ones of the default rules.
+	SStoreRefundHook func(addr common.Address, slot common.Hash, added, subtracted uint64)
+
+	// RefundHook, if set, replaces the default SSTORE refund rules. It's called
+	// with the original, current and new value of every written slot and
+	// returns the gas to add to (or, if negative, remove from) the refund
+	// counter. The gas cost of the SSTORE itself is unaffected.
+	RefundHook func(op OpCode, original, current, new common.Hash) int64
+
+	// CustomContextOpcodes installs additional opcodes into unused slots of the
+	// default jump table, each pushing the value returned by its function. This
+	// allows chains to expose extra context (e.g. L1 fee data) without forking
+	// the interpreter.
+	CustomContextOpcodes map[OpCode]func(*EVM) *uint256.Int
 }
 
 // Interpreter is used to run Ethereum based contracts and will utilise the
@@ -80,55 +109,123 @@ type keccakState interface {
 
 // EVMInterpreter represents an EVM interpreter
 type EVMInterpreter struct {
-	evm *EVM
-	cfg Config
+	evm   *EVM
+	cfg   Config
+	table *JumpTable
 
 	hasher    keccakState // Keccak256 hasher instance shared across opcodes
 	hasherBuf common.Hash // Keccak256 hasher result array shared aross opcodes
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	annotations map[string]interface{} // Extra context of the current step, nil unless traced by a StepAnnotator
+	opcodeStats *[256]uint64           // Number of executions of each opcode, nil unless counted
+	opcodeSent  *[256]uint64           // Executions already added to the opcode metrics, nil unless enabled
+	opcodeGas   *[256]OpcodeGas        // Gas spent by each opcode, nil unless enabled
+}
+
+// OpcodeGas is the gas spent executing an opcode, split into its constant and
+// dynamic (e.g. memory expansion, storage access) portions.
+type OpcodeGas struct {
+	Constant uint64
+	Dynamic  uint64
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
 func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 	// We use the STOP instruction whether to see
 	// the jump table was initialised. If it was not
-	// we'll set the default jump table.
-	if cfg.JumpTable[STOP] == nil {
-		var jt JumpTable
-		switch {
-		case evm.chainRules.IsBerlin:
-			jt = berlinInstructionSet
-		case evm.chainRules.IsIstanbul:
-			jt = istanbulInstructionSet
-		case evm.chainRules.IsConstantinople:
-			jt = constantinopleInstructionSet
-		case evm.chainRules.IsByzantium:
-			jt = byzantiumInstructionSet
-		case evm.chainRules.IsEIP158:
-			jt = spuriousDragonInstructionSet
-		case evm.chainRules.IsEIP150:
-			jt = tangerineWhistleInstructionSet
-		case evm.chainRules.IsHomestead:
-			jt = homesteadInstructionSet
-		default:
-			jt = frontierInstructionSet
+	// we'll use the shared default jump table of the fork.
+	var table *JumpTable
+	if cfg.JumpTable[STOP] != nil {
+		table = &JumpTable{ops: cfg.JumpTable}
+	} else {
+		table = defaultJumpTable(evm.chainRules)
+
+		// Customisations are applied on a copy, replacing only the modified operations
+		if len(cfg.ExtraEips) > 0 || cfg.BlobGasUsedOpcode || len(cfg.CustomContextOpcodes) > 0 || evm.chainConfig.IsGasCosts(evm.Context.BlockNumber) {
+			custom := *table
+			table = &custom
 		}
 		for i, eip := range cfg.ExtraEips {
-			if err := EnableEIP(eip, &jt); err != nil {
+			if err := EnableEIP(eip, table); err != nil {
 				// Disable it, so caller can check if it's activated or not
 				cfg.ExtraEips = append(cfg.ExtraEips[:i], cfg.ExtraEips[i+1:]...)
 				log.Error("EIP activation failed", "eip", eip, "error", err)
 			}
 		}
-		cfg.JumpTable = jt
+		if cfg.BlobGasUsedOpcode {
+			enableBlobGasUsed(table)
+		}
+		for op, fn := range cfg.CustomContextOpcodes {
+			if table.ops[op] != nil {
+				log.Error("Custom opcode activation failed", "op", op, "error", "opcode already defined")
+				continue
+			}
+			table.ops[op] = &operation{
+				execute:     makeContextOpcode(fn),
+				constantGas: GasQuickStep,
+				minStack:    minStack(0, 1),
+				maxStack:    maxStack(0, 1),
+			}
+		}
+		// Apply any chain specific gas cost overrides
+		if evm.chainConfig.IsGasCosts(evm.Context.BlockNumber) {
+			copyOperation(table, CREATE).constantGas = evm.chainConfig.CreateGas(evm.Context.BlockNumber)
+			if table.ops[CREATE2] != nil {
+				copyOperation(table, CREATE2).constantGas = evm.chainConfig.Create2Gas(evm.Context.BlockNumber)
+			}
+		}
+	}
+	in := &EVMInterpreter{
+		evm:   evm,
+		cfg:   cfg,
+		table: table,
 	}
 
-	return &EVMInterpreter{
-		evm: evm,
-		cfg: cfg,
+	// The opcode metrics are fed from the histogram, counting every step once
+	if cfg.OpcodeStats || cfg.OpcodeMetrics {
+		in.opcodeStats = new([256]uint64)
 	}
+	if cfg.OpcodeMetrics {
+		in.opcodeSent = new([256]uint64)
+	}
+	if cfg.GasBreakdown {
+		in.opcodeGas = new([256]OpcodeGas)
+	}
+	return in
+}
+
+// OpcodeStats returns the number of times each opcode was executed by the
+// interpreter, or nil if Config.OpcodeStats is not set.
+func (in *EVMInterpreter) OpcodeStats() map[OpCode]uint64 {
+	if !in.cfg.OpcodeStats {
+		return nil
+	}
+	stats := make(map[OpCode]uint64)
+	for op, count := range in.opcodeStats {
+		if count > 0 {
+			stats[OpCode(op)] = count
+		}
+	}
+	return stats
+}
+
+// GasBreakdown returns the gas spent by each opcode executed by the interpreter,
+// or nil if Config.GasBreakdown is not set. Note, the dynamic gas of calls and
+// creations includes the gas forwarded to the callee, even if later returned.
+func (in *EVMInterpreter) GasBreakdown() map[OpCode]OpcodeGas {
+	if in.opcodeGas == nil {
+		return nil
+	}
+	breakdown := make(map[OpCode]OpcodeGas)
+	for op, gas := range in.opcodeGas {
+		if gas.Constant > 0 || gas.Dynamic > 0 {
+			breakdown[OpCode(op)] = gas
+		}
+	}
+	return breakdown
 }
 
 // Run loops and evaluates the contract's code with the given input data and returns
@@ -158,6 +255,11 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	if len(contract.Code) == 0 {
 		return nil, nil
 	}
+	// Share the JUMPDEST analysis of deployed code across all calls of the EVM
+	contract.jumpdests = in.evm.jumpdests
+
+	defer func(frame *Contract) { in.evm.frame = frame }(in.evm.frame)
+	in.evm.frame = contract
 
 	var (
 		op          OpCode        // current opcode
@@ -178,6 +280,9 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		gasCopy uint64 // for Tracer to log gas remaining before execution
 		logged  bool   // deferred Tracer should ignore already logged steps
 		res     []byte // result of the opcode execution function
+
+		stats    = in.opcodeStats // opcode execution histogram, nil if disabled
+		gasSpent = in.opcodeGas   // opcode gas breakdown, nil if disabled
 	)
 	// Don't move this deferrred function, it's placed before the capturestate-deferred method,
 	// so that it get's executed _after_: the capturestate needs the stacks before
@@ -185,8 +290,35 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	defer func() {
 		returnStack(stack)
 	}()
+	// Release the memory of the frame from the call tree's total once it returns
+	if in.cfg.MaxTotalMemory > 0 {
+		defer func() { in.evm.totalMemory -= uint64(mem.Len()) }()
+	}
 	contract.Input = input
 
+	// Publish the opcodes counted during the whole call tree once it returns
+	if in.opcodeSent != nil && in.evm.depth == 1 {
+		defer in.sendOpcodeMetrics()
+	}
+	// Give every frame its own annotations, as the steps of nested calls run in
+	// between the execution of a call opcode and its annotations being captured
+	var (
+		annotator StepAnnotator
+		backEdges BackEdgeTracer
+		refunds   RefundTracer
+		stackLens StackTracer
+		refund    uint64 // refund counter before charging the dynamic gas of a step
+	)
+	if in.cfg.Debug {
+		if annotator, _ = in.cfg.Tracer.(StepAnnotator); annotator != nil {
+			defer func(annotations map[string]interface{}) { in.annotations = annotations }(in.annotations)
+			in.annotations = make(map[string]interface{})
+		}
+		backEdges, _ = in.cfg.Tracer.(BackEdgeTracer)
+		refunds, _ = in.cfg.Tracer.(RefundTracer)
+		stackLens, _ = in.cfg.Tracer.(StackTracer)
+	}
+
 	if in.cfg.Debug {
 		defer func() {
 			if err != nil {
@@ -198,6 +330,16 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			}
 		}()
 	}
+	// Deferred last so that it runs first, letting the tracer above observe the
+	// converted error. This is opt-in, as it would otherwise mask bugs in tests.
+	if in.cfg.RecoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("EVM panic recovered", "pc", pc, "op", op, "err", r, "stack", string(debug.Stack()))
+				ret, err = nil, &ErrVMPanic{opcode: op, pc: pc, reason: r}
+			}
+		}()
+	}
 	// The Interpreter main run loop (contextual). This loop runs until either an
 	// explicit STOP, RETURN or SELFDESTRUCT is executed, an error occurred during
 	// the execution of one of the operations or until the done flag is set by the
@@ -216,7 +358,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		// Get the operation from the jump table and validate the stack to ensure there are
 		// enough stack items available to perform the operation.
 		op = contract.GetOp(pc)
-		operation := in.cfg.JumpTable[op]
+		operation := in.table.ops[op]
 		if operation == nil {
 			return nil, &ErrInvalidOpCode{opcode: op}
 		}
@@ -242,6 +384,9 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		if !contract.UseGas(operation.constantGas) {
 			return nil, ErrOutOfGas
 		}
+		if gasSpent != nil {
+			gasSpent[op].Constant += operation.constantGas
+		}
 
 		var memorySize uint64
 		// calculate the new memory size and expand the memory to fit
@@ -258,29 +403,74 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
 				return nil, ErrGasUintOverflow
 			}
+			// Gas normally bounds memory expansion, but not if it's set arbitrarily
+			// high, e.g. in simulations. Refuse to allocate beyond the configured cap.
+			if in.cfg.MaxMemorySize > 0 && memorySize > in.cfg.MaxMemorySize {
+				return nil, ErrMaxMemoryExceeded
+			}
+			// The same applies to the memory of a call tree, which can exceed that
+			// of any single frame by a lot
+			if in.cfg.MaxTotalMemory > 0 && memorySize > uint64(mem.Len()) {
+				if memorySize-uint64(mem.Len()) > in.cfg.MaxTotalMemory-in.evm.totalMemory {
+					return nil, ErrTotalMemoryExceeded
+				}
+			}
 		}
 		// Dynamic portion of gas
 		// consume the gas and return an error if not enough gas is available.
 		// cost is explicitly set so that the capture state defer method can get the proper cost
 		if operation.dynamicGas != nil {
+			if refunds != nil {
+				refund = in.evm.StateDB.GetRefund()
+			}
 			var dynamicCost uint64
 			dynamicCost, err = operation.dynamicGas(in.evm, contract, stack, mem, memorySize)
 			cost += dynamicCost // total cost, for debug tracing
 			if err != nil || !contract.UseGas(dynamicCost) {
 				return nil, ErrOutOfGas
 			}
+			if gasSpent != nil {
+				gasSpent[op].Dynamic += dynamicCost
+			}
 		}
 		if memorySize > 0 {
+			if in.cfg.MaxTotalMemory > 0 && memorySize > uint64(mem.Len()) {
+				in.evm.totalMemory += memorySize - uint64(mem.Len())
+			}
 			mem.Resize(memorySize)
 		}
 
 		if in.cfg.Debug {
 			in.cfg.Tracer.CaptureState(in.evm, pc, op, gasCopy, cost, callContext, in.returnData, in.evm.depth, err)
 			logged = true
+
+			if refunds != nil && operation.dynamicGas != nil {
+				if delta := int64(in.evm.StateDB.GetRefund()) - int64(refund); delta != 0 {
+					refunds.CaptureRefund(in.evm, pc, op, in.evm.depth, delta)
+				}
+			}
+
+			// A closed stepper aborts every frame before the paused opcode runs
+			if in.evm.stepAborted {
+				return nil, ErrExecutionAborted
+			}
 		}
 
+		if stats != nil {
+			stats[op]++
+		}
 		// execute the operation
 		res, err = operation.execute(&pc, in, callContext)
+		if annotator != nil && len(in.annotations) > 0 {
+			annotator.CaptureAnnotations(in.evm, pcCopy, op, in.evm.depth, in.annotations)
+			in.annotations = make(map[string]interface{})
+		}
+		if backEdges != nil && operation.jumps && err == nil && pc < pcCopy {
+			backEdges.CaptureBackEdge(in.evm, pcCopy, pc, in.evm.depth)
+		}
+		if stackLens != nil && err == nil {
+			stackLens.CaptureStackLen(in.evm, pcCopy, op, in.evm.depth, stack.len())
+		}
 		// if the operation clears the return data (e.g. it has returning data)
 		// set the last return to the result of the operation.
 		if operation.returns {
@@ -0,0 +1,323 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// panicOp is an opcode slot unused by all forks, filled with a panicking
+// implementation by the tests below.
+const panicOp = OpCode(0x0c)
+
+func newPanicEVM(recover bool) (*EVM, common.Address) {
+	jt := newBerlinInstructionSet()
+	jt.ops[panicOp] = &operation{
+		execute: func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+			panic("deliberate opcode failure")
+		},
+		minStack: minStack(0, 0),
+		maxStack: maxStack(0, 0),
+	}
+	return newTestEVM([]byte{byte(PUSH1), 0x01, byte(panicOp)}, Config{JumpTable: jt.ops, RecoverPanics: recover})
+}
+
+func TestRecoverPanics(t *testing.T) {
+	evm, address := newPanicEVM(true)
+	_, gas, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+
+	var perr *ErrVMPanic
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected ErrVMPanic, got %v", err)
+	}
+	if perr.opcode != panicOp || perr.pc != 2 {
+		t.Errorf("panic location mismatch: have op %v pc %d, want op %v pc %d", perr.opcode, perr.pc, panicOp, 2)
+	}
+	if gas != 0 {
+		t.Errorf("expected all gas to be consumed, %d left", gas)
+	}
+}
+
+func TestPanicsNotRecoveredByDefault(t *testing.T) {
+	evm, address := newPanicEVM(false)
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected panic to propagate")
+		}
+	}()
+	evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+}
+
+// TestOpcodeMetrics checks that executed opcodes are counted in the metrics
+// registry when enabled, once per execution even alongside the histogram.
+func TestOpcodeMetrics(t *testing.T) {
+	counters := opcodeMetrics()
+	before := map[OpCode]int64{PUSH1: counters[PUSH1].Count(), ADD: counters[ADD].Count(), MUL: counters[MUL].Count()}
+
+	// PUSH1 1, PUSH1 2, ADD, POP, STOP
+	evm, address := newTestEVM([]byte{byte(PUSH1), 1, byte(PUSH1), 2, byte(ADD), byte(POP), byte(STOP)}, Config{OpcodeMetrics: true, OpcodeStats: true})
+	for i := 0; i < 2; i++ {
+		if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil {
+			t.Fatalf("execution %d failed: %v", i, err)
+		}
+	}
+	stats := evm.Interpreter().(*EVMInterpreter).OpcodeStats()
+	for op, want := range map[OpCode]int64{PUSH1: 4, ADD: 2, MUL: 0} {
+		if have := counters[op].Count() - before[op]; have != want {
+			t.Errorf("%v: execution count mismatch: have %d, want %d", op, have, want)
+		}
+		if have := stats[op]; have != uint64(want) {
+			t.Errorf("%v: histogram count mismatch: have %d, want %d", op, have, want)
+		}
+	}
+	if have := metrics.DefaultRegistry.Get("vm/opcodes/ADD"); have != counters[ADD] {
+		t.Errorf("ADD counter not published in the default registry")
+	}
+}
+
+// TestOpcodeStats checks that the per-interpreter opcode histogram counts the
+// executions of a small loop.
+func TestOpcodeStats(t *testing.T) {
+	// Count n from 0 up to 5 in a JUMPI loop
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 0,
+		byte(JUMPDEST),
+		byte(PUSH1), 1, byte(ADD), // n = n + 1
+		byte(DUP1), byte(PUSH1), 5, byte(SUB), // loop while 5 - n != 0
+		byte(PUSH1), 2, byte(JUMPI),
+		byte(STOP),
+	}, Config{OpcodeStats: true})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	stats := evm.Interpreter().(*EVMInterpreter).OpcodeStats()
+	for op, want := range map[OpCode]uint64{ADD: 5, PUSH1: 16, JUMPI: 5, MUL: 0} {
+		if have := stats[op]; have != want {
+			t.Errorf("%v: execution count mismatch: have %d, want %d", op, have, want)
+		}
+	}
+	// Without the flag, no histogram is kept
+	evm, _ = newTestEVM(nil, Config{})
+	if stats := evm.Interpreter().(*EVMInterpreter).OpcodeStats(); stats != nil {
+		t.Errorf("opcode stats kept while disabled: %v", stats)
+	}
+}
+
+// TestGasBreakdown checks that the gas spent by storage and memory writes is
+// split into its constant and dynamic portions.
+func TestGasBreakdown(t *testing.T) {
+	// sstore(0, 1), mstore(0, 1)
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE),
+		byte(PUSH1), 1, byte(PUSH1), 0, byte(MSTORE),
+		byte(STOP),
+	}, Config{GasBreakdown: true})
+	evm.StateDB.AddAddressToAccessList(address)
+
+	if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	breakdown := evm.Interpreter().(*EVMInterpreter).GasBreakdown()
+	want := map[OpCode]OpcodeGas{
+		PUSH1:  {Constant: 4 * GasFastestStep},
+		SSTORE: {Dynamic: params.SstoreSetGasEIP2200 + ColdSloadCostEIP2929},
+		MSTORE: {Constant: GasFastestStep, Dynamic: params.MemoryGas},
+	}
+	if !reflect.DeepEqual(breakdown, want) {
+		t.Errorf("gas breakdown mismatch: have %+v, want %+v", breakdown, want)
+	}
+}
+
+// TestCustomContextOpcode checks that a custom context opcode can be installed
+// into an unused slot and pushes the value provided by its function.
+func TestCustomContextOpcode(t *testing.T) {
+	custom := OpCode(0x0c)
+	cfg := Config{
+		CustomContextOpcodes: map[OpCode]func(*EVM) *uint256.Int{
+			custom: func(*EVM) *uint256.Int { return new(uint256.Int).SetUint64(42) },
+		},
+	}
+	// custom, PUSH1 0, MSTORE, PUSH1 32, PUSH1 0, RETURN
+	evm, address := newTestEVM([]byte{
+		byte(custom), byte(PUSH1), 0, byte(MSTORE),
+		byte(PUSH1), 32, byte(PUSH1), 0, byte(RETURN),
+	}, cfg)
+	ret, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+	if err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if have := new(uint256.Int).SetBytes(ret); have.Uint64() != 42 {
+		t.Errorf("custom opcode result mismatch: have %v, want 42", have)
+	}
+	// The shared jump table must not see the custom opcode
+	plain := NewEVM(evm.Context, TxContext{}, evm.StateDB, params.AllEthashProtocolChanges, Config{})
+	if _, _, err := plain.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err == nil {
+		t.Errorf("custom opcode leaked into the default jump table")
+	}
+}
+
+// annotationTracer collects the annotations of all steps.
+type annotationTracer struct {
+	*StructLogger
+	steps       []OpCode
+	annotations []map[string]interface{}
+}
+
+func (t *annotationTracer) CaptureAnnotations(env *EVM, pc uint64, op OpCode, depth int, annotations map[string]interface{}) {
+	t.steps = append(t.steps, op)
+	t.annotations = append(t.annotations, annotations)
+}
+
+// TestStepAnnotations checks that SLOAD annotates its step with the slot and the
+// value it read.
+func TestStepAnnotations(t *testing.T) {
+	var (
+		slot   = common.BigToHash(big.NewInt(1))
+		value  = common.BigToHash(big.NewInt(42))
+		tracer = &annotationTracer{StructLogger: NewStructLogger(nil)}
+	)
+	// PUSH1 1, SLOAD, POP, STOP
+	evm, address := newTestEVM([]byte{byte(PUSH1), 1, byte(SLOAD), byte(POP), byte(STOP)}, Config{Debug: true, Tracer: tracer})
+	evm.StateDB.SetState(address, slot, value)
+	evm.StateDB.AddAddressToAccessList(address)
+
+	if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if len(tracer.steps) != 1 || tracer.steps[0] != SLOAD {
+		t.Fatalf("annotated steps mismatch: have %v, want [SLOAD]", tracer.steps)
+	}
+	want := map[string]interface{}{"slot": slot, "value": value}
+	if have := tracer.annotations[0]; !reflect.DeepEqual(have, want) {
+		t.Errorf("annotations mismatch: have %v, want %v", have, want)
+	}
+}
+
+// TestMaxMemorySize checks that memory expansion beyond the configured cap is
+// refused even if there's enough gas to pay for it.
+func TestMaxMemorySize(t *testing.T) {
+	var (
+		statedb = newTestState()
+		address = common.BytesToAddress([]byte("contract"))
+		vmctx   = testBlockContext()
+	)
+	// PUSH1 1, PUSH3 offset, MSTORE, STOP
+	store := func(offset uint32) []byte {
+		return []byte{byte(PUSH1), 1, byte(PUSH3), byte(offset >> 16), byte(offset >> 8), byte(offset), byte(MSTORE), byte(STOP)}
+	}
+	cfg := Config{MaxMemorySize: 1024}
+	for _, test := range []struct {
+		offset uint32
+		err    error
+	}{
+		{0, nil},
+		{1024 - 32, nil},
+		{1024 - 31, ErrMaxMemoryExceeded},
+		{0x100000, ErrMaxMemoryExceeded},
+	} {
+		statedb.SetCode(address, store(test.offset))
+		evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, cfg)
+		if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, math.MaxUint64, new(big.Int)); err != test.err {
+			t.Errorf("offset %d: error mismatch: have %v, want %v", test.offset, err, test.err)
+		}
+	}
+	// Without a cap, the same expansion succeeds given enough gas
+	evm := NewEVM(vmctx, TxContext{}, statedb, params.AllEthashProtocolChanges, Config{})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, math.MaxUint64, new(big.Int)); err != nil {
+		t.Errorf("uncapped expansion failed: %v", err)
+	}
+}
+
+// TestMaxTotalMemory checks that the memory allocated by a call tree is capped
+// across all of its active frames.
+func TestMaxTotalMemory(t *testing.T) {
+	code := []byte{
+		byte(PUSH1), 0, byte(SLOAD), byte(PUSH1), 1, byte(ADD), byte(PUSH1), 0, byte(SSTORE), // count frames
+		byte(PUSH1), 1, byte(PUSH2), 0x03, 0xe0, byte(MSTORE), // expand memory to 1KB
+		byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0, byte(PUSH1), 0,
+		byte(ADDRESS), byte(GAS), byte(CALL), byte(POP), byte(STOP), // recurse
+	}
+	for _, test := range []struct {
+		limit  uint64
+		frames uint64
+	}{
+		{4096, 4},
+		{4095, 3},
+		{0, 0}, // unlimited, bounded by gas only
+	} {
+		evm, address := newTestEVM(code, Config{MaxTotalMemory: test.limit})
+		evm.StateDB.AddAddressToAccessList(address)
+
+		if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 1000000, new(big.Int)); err != nil {
+			t.Fatalf("limit %d: call failed: %v", test.limit, err)
+		}
+		frames := evm.StateDB.GetState(address, common.Hash{}).Big().Uint64()
+		if test.limit == 0 {
+			if frames <= 4 {
+				t.Errorf("unlimited: frame count too low: have %d", frames)
+			}
+		} else if frames != test.frames {
+			t.Errorf("limit %d: frame count mismatch: have %d, want %d", test.limit, frames, test.frames)
+		}
+		if evm.totalMemory != 0 {
+			t.Errorf("limit %d: memory not released: have %d", test.limit, evm.totalMemory)
+		}
+	}
+}
+
+// backEdgeTracer counts the back-edges traversed, by source and destination.
+type backEdgeTracer struct {
+	*StructLogger
+	edges map[[2]uint64]int
+}
+
+func (t *backEdgeTracer) CaptureBackEdge(env *EVM, from, to uint64, depth int) {
+	t.edges[[2]uint64{from, to}]++
+}
+
+// TestBackEdgeCount checks that every backwards jump of a loop is reported to
+// the tracer, while forward jumps are not.
+func TestBackEdgeCount(t *testing.T) {
+	// for n := 50; n != 0; n-- {}
+	tracer := &backEdgeTracer{StructLogger: NewStructLogger(nil), edges: make(map[[2]uint64]int)}
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 50,
+		byte(JUMPDEST),                            // 2: loop head
+		byte(DUP1), byte(ISZERO), byte(PUSH1), 15, // exit if n == 0
+		byte(JUMPI),
+		byte(PUSH1), 1, byte(SWAP1), byte(SUB), // n = n - 1
+		byte(PUSH1), 2, byte(JUMP), // 14: back to the loop head
+		byte(JUMPDEST), // 15: loop exit
+		byte(STOP),
+	}, Config{Debug: true, Tracer: tracer})
+	if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	want := map[[2]uint64]int{{14, 2}: 50}
+	if !reflect.DeepEqual(tracer.edges, want) {
+		t.Errorf("back-edges mismatch: have %v, want %v", tracer.edges, want)
+	}
+}
@@ -17,6 +17,9 @@
 package vm
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -48,25 +51,90 @@ type operation struct {
 	returns bool // determines whether the operations sets the return data content
 }
 
+// instructionSet identifies the default jump table of a fork.
+type instructionSet int
+
+const (
+	frontierInstructionSet instructionSet = iota
+	homesteadInstructionSet
+	tangerineWhistleInstructionSet
+	spuriousDragonInstructionSet
+	byzantiumInstructionSet
+	constantinopleInstructionSet
+	istanbulInstructionSet
+	berlinInstructionSet
+	numInstructionSets
+)
+
 var (
-	frontierInstructionSet         = newFrontierInstructionSet()
-	homesteadInstructionSet        = newHomesteadInstructionSet()
-	tangerineWhistleInstructionSet = newTangerineWhistleInstructionSet()
-	spuriousDragonInstructionSet   = newSpuriousDragonInstructionSet()
-	byzantiumInstructionSet        = newByzantiumInstructionSet()
-	constantinopleInstructionSet   = newConstantinopleInstructionSet()
-	istanbulInstructionSet         = newIstanbulInstructionSet()
-	berlinInstructionSet           = newBerlinInstructionSet()
+	// instructionSetBuilders are the constructors of the default jump tables.
+	instructionSetBuilders = [numInstructionSets]func() JumpTable{
+		frontierInstructionSet:         newFrontierInstructionSet,
+		homesteadInstructionSet:        newHomesteadInstructionSet,
+		tangerineWhistleInstructionSet: newTangerineWhistleInstructionSet,
+		spuriousDragonInstructionSet:   newSpuriousDragonInstructionSet,
+		byzantiumInstructionSet:        newByzantiumInstructionSet,
+		constantinopleInstructionSet:   newConstantinopleInstructionSet,
+		istanbulInstructionSet:         newIstanbulInstructionSet,
+		berlinInstructionSet:           newBerlinInstructionSet,
+	}
+	// instructionSets caches the lazily constructed default jump tables. They are
+	// shared by all interpreters and must never be modified.
+	instructionSets     [numInstructionSets]*JumpTable
+	instructionSetsOnce [numInstructionSets]sync.Once
 )
 
-// JumpTable contains the EVM opcodes supported at a given fork.
-type JumpTable [256]*operation
+// JumpTable contains the EVM opcodes supported at a given fork, along with the
+// EIPs enabled on top of them. Copies of a table share its operations, which
+// are replaced via copyOperation rather than modified in place.
+type JumpTable struct {
+	ops  [256]*operation
+	eips []eipActivation // EIPs applied via EnableEIP, in order of activation
+}
+
+// defaultJumpTable returns the shared, read-only jump table for the given chain
+// rules, constructing it on first use. Callers wishing to modify the table must
+// operate on a copy of it.
+func defaultJumpTable(rules params.Rules) *JumpTable {
+	var set instructionSet
+	switch {
+	case rules.IsBerlin:
+		set = berlinInstructionSet
+	case rules.IsIstanbul:
+		set = istanbulInstructionSet
+	case rules.IsConstantinople:
+		set = constantinopleInstructionSet
+	case rules.IsByzantium:
+		set = byzantiumInstructionSet
+	case rules.IsEIP158:
+		set = spuriousDragonInstructionSet
+	case rules.IsEIP150:
+		set = tangerineWhistleInstructionSet
+	case rules.IsHomestead:
+		set = homesteadInstructionSet
+	default:
+		set = frontierInstructionSet
+	}
+	instructionSetsOnce[set].Do(func() {
+		jt := instructionSetBuilders[set]()
+		instructionSets[set] = &jt
+	})
+	return instructionSets[set]
+}
+
+// copyOperation replaces the operation of the given opcode with a private copy,
+// which can be modified without affecting the tables sharing the original.
+func copyOperation(jt *JumpTable, op OpCode) *operation {
+	opCopy := *jt.ops[op]
+	jt.ops[op] = &opCopy
+	return &opCopy
+}
 
 // newBerlinInstructionSet returns the frontier, homestead, byzantium,
 // contantinople, istanbul, petersburg and berlin instructions.
 func newBerlinInstructionSet() JumpTable {
 	instructionSet := newIstanbulInstructionSet()
-	enable2929(&instructionSet) // Access lists for trie accesses https://eips.ethereum.org/EIPS/eip-2929
+	mustEnableEIP(2929, &instructionSet) // Access lists for trie accesses https://eips.ethereum.org/EIPS/eip-2929
 	return instructionSet
 }
 
@@ -75,9 +143,9 @@ func newBerlinInstructionSet() JumpTable {
 func newIstanbulInstructionSet() JumpTable {
 	instructionSet := newConstantinopleInstructionSet()
 
-	enable1344(&instructionSet) // ChainID opcode - https://eips.ethereum.org/EIPS/eip-1344
-	enable1884(&instructionSet) // Reprice reader opcodes - https://eips.ethereum.org/EIPS/eip-1884
-	enable2200(&instructionSet) // Net metered SSTORE - https://eips.ethereum.org/EIPS/eip-2200
+	mustEnableEIP(1344, &instructionSet) // ChainID opcode - https://eips.ethereum.org/EIPS/eip-1344
+	mustEnableEIP(1884, &instructionSet) // Reprice reader opcodes - https://eips.ethereum.org/EIPS/eip-1884
+	mustEnableEIP(2200, &instructionSet) // Net metered SSTORE - https://eips.ethereum.org/EIPS/eip-2200
 
 	return instructionSet
 }
@@ -86,31 +154,31 @@ func newIstanbulInstructionSet() JumpTable {
 // byzantium and contantinople instructions.
 func newConstantinopleInstructionSet() JumpTable {
 	instructionSet := newByzantiumInstructionSet()
-	instructionSet[SHL] = &operation{
+	instructionSet.ops[SHL] = &operation{
 		execute:     opSHL,
 		constantGas: GasFastestStep,
 		minStack:    minStack(2, 1),
 		maxStack:    maxStack(2, 1),
 	}
-	instructionSet[SHR] = &operation{
+	instructionSet.ops[SHR] = &operation{
 		execute:     opSHR,
 		constantGas: GasFastestStep,
 		minStack:    minStack(2, 1),
 		maxStack:    maxStack(2, 1),
 	}
-	instructionSet[SAR] = &operation{
+	instructionSet.ops[SAR] = &operation{
 		execute:     opSAR,
 		constantGas: GasFastestStep,
 		minStack:    minStack(2, 1),
 		maxStack:    maxStack(2, 1),
 	}
-	instructionSet[EXTCODEHASH] = &operation{
+	instructionSet.ops[EXTCODEHASH] = &operation{
 		execute:     opExtCodeHash,
 		constantGas: params.ExtcodeHashGasConstantinople,
 		minStack:    minStack(1, 1),
 		maxStack:    maxStack(1, 1),
 	}
-	instructionSet[CREATE2] = &operation{
+	instructionSet.ops[CREATE2] = &operation{
 		execute:     opCreate2,
 		constantGas: params.Create2Gas,
 		dynamicGas:  gasCreate2,
@@ -127,7 +195,7 @@ func newConstantinopleInstructionSet() JumpTable {
 // byzantium instructions.
 func newByzantiumInstructionSet() JumpTable {
 	instructionSet := newSpuriousDragonInstructionSet()
-	instructionSet[STATICCALL] = &operation{
+	instructionSet.ops[STATICCALL] = &operation{
 		execute:     opStaticCall,
 		constantGas: params.CallGasEIP150,
 		dynamicGas:  gasStaticCall,
@@ -136,13 +204,13 @@ func newByzantiumInstructionSet() JumpTable {
 		memorySize:  memoryStaticCall,
 		returns:     true,
 	}
-	instructionSet[RETURNDATASIZE] = &operation{
+	instructionSet.ops[RETURNDATASIZE] = &operation{
 		execute:     opReturnDataSize,
 		constantGas: GasQuickStep,
 		minStack:    minStack(0, 1),
 		maxStack:    maxStack(0, 1),
 	}
-	instructionSet[RETURNDATACOPY] = &operation{
+	instructionSet.ops[RETURNDATACOPY] = &operation{
 		execute:     opReturnDataCopy,
 		constantGas: GasFastestStep,
 		dynamicGas:  gasReturnDataCopy,
@@ -150,7 +218,7 @@ func newByzantiumInstructionSet() JumpTable {
 		maxStack:    maxStack(3, 0),
 		memorySize:  memoryReturnDataCopy,
 	}
-	instructionSet[REVERT] = &operation{
+	instructionSet.ops[REVERT] = &operation{
 		execute:    opRevert,
 		dynamicGas: gasRevert,
 		minStack:   minStack(2, 0),
@@ -165,7 +233,7 @@ func newByzantiumInstructionSet() JumpTable {
 // EIP 158 a.k.a Spurious Dragon
 func newSpuriousDragonInstructionSet() JumpTable {
 	instructionSet := newTangerineWhistleInstructionSet()
-	instructionSet[EXP].dynamicGas = gasExpEIP158
+	instructionSet.ops[EXP].dynamicGas = gasExpEIP158
 	return instructionSet
 
 }
@@ -173,13 +241,13 @@ func newSpuriousDragonInstructionSet() JumpTable {
 // EIP 150 a.k.a Tangerine Whistle
 func newTangerineWhistleInstructionSet() JumpTable {
 	instructionSet := newHomesteadInstructionSet()
-	instructionSet[BALANCE].constantGas = params.BalanceGasEIP150
-	instructionSet[EXTCODESIZE].constantGas = params.ExtcodeSizeGasEIP150
-	instructionSet[SLOAD].constantGas = params.SloadGasEIP150
-	instructionSet[EXTCODECOPY].constantGas = params.ExtcodeCopyBaseEIP150
-	instructionSet[CALL].constantGas = params.CallGasEIP150
-	instructionSet[CALLCODE].constantGas = params.CallGasEIP150
-	instructionSet[DELEGATECALL].constantGas = params.CallGasEIP150
+	instructionSet.ops[BALANCE].constantGas = params.BalanceGasEIP150
+	instructionSet.ops[EXTCODESIZE].constantGas = params.ExtcodeSizeGasEIP150
+	instructionSet.ops[SLOAD].constantGas = params.SloadGasEIP150
+	instructionSet.ops[EXTCODECOPY].constantGas = params.ExtcodeCopyBaseEIP150
+	instructionSet.ops[CALL].constantGas = params.CallGasEIP150
+	instructionSet.ops[CALLCODE].constantGas = params.CallGasEIP150
+	instructionSet.ops[DELEGATECALL].constantGas = params.CallGasEIP150
 	return instructionSet
 }
 
@@ -187,7 +255,7 @@ func newTangerineWhistleInstructionSet() JumpTable {
 // instructions that can be executed during the homestead phase.
 func newHomesteadInstructionSet() JumpTable {
 	instructionSet := newFrontierInstructionSet()
-	instructionSet[DELEGATECALL] = &operation{
+	instructionSet.ops[DELEGATECALL] = &operation{
 		execute:     opDelegateCall,
 		dynamicGas:  gasDelegateCall,
 		constantGas: params.CallGasFrontier,
@@ -202,7 +270,7 @@ func newHomesteadInstructionSet() JumpTable {
 // newFrontierInstructionSet returns the frontier instructions
 // that can be executed during the frontier phase.
 func newFrontierInstructionSet() JumpTable {
-	return JumpTable{
+	return JumpTable{ops: [256]*operation{
 		STOP: {
 			execute:     opStop,
 			constantGas: 0,
@@ -1019,5 +1087,32 @@ func newFrontierInstructionSet() JumpTable {
 			halts:      true,
 			writes:     true,
 		},
+	}}
+}
+
+// ValidateJumpTable sanity checks the defined operations of a jump table: every
+// operation except STOP must charge gas, either as a constant or dynamically,
+// and the stack bounds must describe a valid number of pops and pushes.
+func ValidateJumpTable(jt *JumpTable) error {
+	for i, op := range jt.ops {
+		if op == nil {
+			continue
+		}
+		opcode := OpCode(i)
+		if op.execute == nil {
+			return fmt.Errorf("opcode %v: missing execution function", opcode)
+		}
+		if op.constantGas == 0 && op.dynamicGas == nil && opcode != STOP {
+			return fmt.Errorf("opcode %v: no constant or dynamic gas", opcode)
+		}
+		// maxStack is derived as StackLimit + pops - pushes, with pops == minStack
+		pushes := int(params.StackLimit) + op.minStack - op.maxStack
+		if op.minStack < 0 || op.minStack > int(params.StackLimit) {
+			return fmt.Errorf("opcode %v: invalid min stack %d", opcode, op.minStack)
+		}
+		if pushes < 0 || pushes > int(params.StackLimit) {
+			return fmt.Errorf("opcode %v: invalid max stack %d for min stack %d", opcode, op.maxStack, op.minStack)
+		}
 	}
+	return nil
 }
@@ -0,0 +1,254 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// TestValidateJumpTables checks that all the default instruction sets pass the
+// jump table sanity checks.
+func TestValidateJumpTables(t *testing.T) {
+	for name, jt := range map[string]JumpTable{
+		"frontier":         newFrontierInstructionSet(),
+		"homestead":        newHomesteadInstructionSet(),
+		"tangerineWhistle": newTangerineWhistleInstructionSet(),
+		"spuriousDragon":   newSpuriousDragonInstructionSet(),
+		"byzantium":        newByzantiumInstructionSet(),
+		"constantinople":   newConstantinopleInstructionSet(),
+		"istanbul":         newIstanbulInstructionSet(),
+		"berlin":           newBerlinInstructionSet(),
+	} {
+		if err := ValidateJumpTable(&jt); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestValidateJumpTableFailure checks that an operation charging no gas at all
+// is rejected by the sanity checks.
+func TestValidateJumpTableFailure(t *testing.T) {
+	jt := newBerlinInstructionSet()
+
+	sload := *jt.ops[SLOAD]
+	sload.constantGas, sload.dynamicGas = 0, nil
+	jt.ops[SLOAD] = &sload
+
+	if err := ValidateJumpTable(&jt); err == nil {
+		t.Fatal("expected free SLOAD to fail validation")
+	}
+}
+
+// TestSharedJumpTable checks that interpreters on the same fork share a single
+// default jump table, and that customising an interpreter leaves it untouched.
+func TestSharedJumpTable(t *testing.T) {
+	newInterpreter := func(cfg Config) *EVMInterpreter {
+		vmctx := BlockContext{BlockNumber: new(big.Int)}
+		return NewEVM(vmctx, TxContext{}, nil, params.AllEthashProtocolChanges, cfg).interpreter.(*EVMInterpreter)
+	}
+	var (
+		a = newInterpreter(Config{})
+		b = newInterpreter(Config{})
+		c = newInterpreter(Config{ExtraEips: []int{2200}})
+	)
+	shared := defaultJumpTable(a.evm.chainRules)
+	if a.table != shared || b.table != shared {
+		t.Fatal("interpreters on the same fork use different jump tables")
+	}
+	if c.table == shared {
+		t.Fatal("customised interpreter uses the shared jump table")
+	}
+	// Enabling EIP-2200 on top of Berlin replaces the SSTORE gas function
+	fresh := newBerlinInstructionSet()
+	for i, op := range shared.ops {
+		want := fresh.ops[i]
+		if (op == nil) != (want == nil) {
+			t.Fatalf("opcode %v: definition mismatch", OpCode(i))
+		}
+		if op == nil {
+			continue
+		}
+		if op.constantGas != want.constantGas {
+			t.Errorf("opcode %v: constant gas mutated: have %d, want %d", OpCode(i), op.constantGas, want.constantGas)
+		}
+		if reflect.ValueOf(op.dynamicGas).Pointer() != reflect.ValueOf(want.dynamicGas).Pointer() {
+			t.Errorf("opcode %v: dynamic gas function mutated", OpCode(i))
+		}
+	}
+}
+
+// TestEnableEIPTwice checks that an EIP can only be enabled once on a table,
+// while copies of the table track their activations independently.
+func TestEnableEIPTwice(t *testing.T) {
+	jt := newConstantinopleInstructionSet()
+	if err := EnableEIP(2929, &jt); err != nil {
+		t.Fatalf("failed to enable eip 2929: %v", err)
+	}
+	cpy := jt
+	if err := EnableEIP(2929, &jt); err == nil || err.Error() != "eip 2929 already enabled" {
+		t.Fatalf("repeated activation error mismatch: have %v", err)
+	}
+	if err := EnableEIP(2929, &cpy); err == nil {
+		t.Fatal("copy of the table lost its activated eips")
+	}
+	if err := EnableEIP(1344, &jt); err != nil {
+		t.Fatalf("failed to enable eip 1344: %v", err)
+	}
+	if err := EnableEIP(1344, &cpy); err != nil {
+		t.Fatalf("activation on the original leaked into the copy: %v", err)
+	}
+}
+
+// compareJumpTables returns an error if the two jump tables define different
+// opcodes, or price or implement them differently.
+func compareJumpTables(have, want *JumpTable) error {
+	for i := range have.ops {
+		op, wantOp := have.ops[i], want.ops[i]
+		if (op == nil) != (wantOp == nil) {
+			return fmt.Errorf("opcode %v: definition mismatch", OpCode(i))
+		}
+		if op == nil {
+			continue
+		}
+		if op.constantGas != wantOp.constantGas {
+			return fmt.Errorf("opcode %v: constant gas mismatch: have %d, want %d", OpCode(i), op.constantGas, wantOp.constantGas)
+		}
+		if reflect.ValueOf(op.dynamicGas).Pointer() != reflect.ValueOf(wantOp.dynamicGas).Pointer() {
+			return fmt.Errorf("opcode %v: dynamic gas function mismatch", OpCode(i))
+		}
+		if reflect.ValueOf(op.execute).Pointer() != reflect.ValueOf(wantOp.execute).Pointer() {
+			return fmt.Errorf("opcode %v: execution function mismatch", OpCode(i))
+		}
+	}
+	return nil
+}
+
+// TestDisableEIP checks that disabling an EIP restores the table it was enabled
+// on, and that every EIP lists all the operations it modifies.
+func TestDisableEIP(t *testing.T) {
+	if len(activators) != len(eipOperations) {
+		t.Fatalf("activator count mismatch: %d activators, %d operation lists", len(activators), len(eipOperations))
+	}
+	var (
+		base  = newConstantinopleInstructionSet()
+		fresh = newConstantinopleInstructionSet()
+	)
+	for eip := range activators {
+		if eipOperations[eip] == nil {
+			t.Fatalf("eip %d: no operation list", eip)
+		}
+		// Operations modified without being listed would show up in the base
+		// table, which shares them with the copy
+		jt := base
+		if err := EnableEIP(eip, &jt); err != nil {
+			t.Fatalf("eip %d: failed to enable: %v", eip, err)
+		}
+		if err := compareJumpTables(&base, &fresh); err != nil {
+			t.Errorf("eip %d: base table modified: %v", eip, err)
+		}
+		if err := DisableEIP(eip, &jt); err != nil {
+			t.Fatalf("eip %d: failed to disable: %v", eip, err)
+		}
+		if err := compareJumpTables(&jt, &fresh); err != nil {
+			t.Errorf("eip %d: %v", eip, err)
+		}
+		if err := DisableEIP(eip, &jt); err == nil {
+			t.Errorf("eip %d: disabled twice", eip)
+		}
+	}
+	// Rolling back the last fork EIP yields the previous fork
+	berlin, istanbul := newBerlinInstructionSet(), newIstanbulInstructionSet()
+	if err := DisableEIP(2929, &berlin); err != nil {
+		t.Fatalf("failed to disable eip 2929: %v", err)
+	}
+	if err := compareJumpTables(&berlin, &istanbul); err != nil {
+		t.Errorf("berlin without eip 2929: %v", err)
+	}
+	// Rolling back an EIP followed by others touching the same operations keeps
+	// the effects of the latter
+	if err := DisableEIP(1884, &istanbul); err != nil {
+		t.Fatalf("failed to disable eip 1884: %v", err)
+	}
+	if istanbul.ops[SELFBALANCE] != nil {
+		t.Error("SELFBALANCE still defined")
+	}
+	if have, want := istanbul.ops[BALANCE].constantGas, params.BalanceGasEIP150; have != want {
+		t.Errorf("BALANCE gas mismatch: have %d, want %d", have, want)
+	}
+	if have, want := istanbul.ops[SLOAD].constantGas, params.SloadGasEIP2200; have != want {
+		t.Errorf("SLOAD gas mismatch: have %d, want %d", have, want)
+	}
+	if err := EnableEIP(1884, &istanbul); err != nil {
+		t.Fatalf("failed to re-enable eip 1884: %v", err)
+	}
+	if err := compareJumpTables(&istanbul, &berlin); err != nil {
+		t.Errorf("istanbul with eip 1884 re-enabled: %v", err)
+	}
+}
+
+func TestValidEipOrError(t *testing.T) {
+	if err := ValidEipOrError(2929); err != nil {
+		t.Fatalf("eip 2929 rejected: %v", err)
+	}
+	want := "undefined eip 9999, supported: [1344 1884 2200 2929 2935 4844 5656 7702]"
+	if err := ValidEipOrError(9999); err == nil || err.Error() != want {
+		t.Fatalf("error mismatch: have %v, want %q", err, want)
+	}
+}
+
+// TestJumpTableCopyIsolation checks that copies of the shared jump tables can be
+// modified without affecting the original or each other.
+func TestJumpTableCopyIsolation(t *testing.T) {
+	var (
+		shared = defaultJumpTable(params.Rules{IsBerlin: true})
+		fresh  = newBerlinInstructionSet()
+		a      = *shared
+		b      = *shared
+	)
+	copyOperation(&a, ADD).constantGas = 1000
+	a.ops[MUL] = nil
+	if err := DisableEIP(2929, &a); err != nil {
+		t.Fatalf("failed to disable eip 2929: %v", err)
+	}
+	if err := EnableEIP(5656, &a); err != nil {
+		t.Fatalf("failed to enable eip 5656: %v", err)
+	}
+	if err := EnableEIP(2935, &b); err != nil {
+		t.Fatalf("failed to enable eip 2935: %v", err)
+	}
+	if err := compareJumpTables(shared, &fresh); err != nil {
+		t.Errorf("shared table modified: %v", err)
+	}
+	if !eipEnabled(shared, 2929) || eipEnabled(shared, 5656) || eipEnabled(shared, 2935) {
+		t.Errorf("eip activations leaked into the shared table")
+	}
+	// Enabling different EIPs on two copies records them separately
+	if !eipEnabled(&a, 5656) || eipEnabled(&a, 2935) {
+		t.Errorf("eip activations mixed up")
+	}
+	if have := b.ops[ADD].constantGas; have != GasFastestStep {
+		t.Errorf("ADD gas mismatch: have %d, want %d", have, GasFastestStep)
+	}
+	if b.ops[MUL] == nil || !eipEnabled(&b, 2929) || eipEnabled(&b, 5656) || b.ops[MCOPY] != nil {
+		t.Errorf("changes to one copy leaked into another")
+	}
+}
@@ -51,6 +51,8 @@ type LogConfig struct {
 	DisableReturnData bool // disable return data capture
 	Debug             bool // print output during capture end
 	Limit             int  // maximum length of output, but zero means unlimited
+	FinalStack        bool // capture the stack at the halting step of the top-level call
+	StackDepths       bool // record the stack length after every step, ignoring the limit
 	// Chain overrides, can be used to execute a trace using future fork rules
 	Overrides *params.ChainConfig `json:"overrides,omitempty"`
 }
@@ -71,6 +73,7 @@ type StructLog struct {
 	Storage       map[common.Hash]common.Hash `json:"-"`
 	Depth         int                         `json:"depth"`
 	RefundCounter uint64                      `json:"refund"`
+	RefundDelta   int64                       `json:"refundDelta,omitempty"`
 	Err           error                       `json:"-"`
 }
 
@@ -110,6 +113,51 @@ type Tracer interface {
 	CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error)
 }
 
+// StepAnnotator is an optional interface for tracers, receiving the extra context
+// some opcodes attach to a step once it was executed, such as the slot and value
+// read by an SLOAD. The annotations are only passed for steps having any, and
+// may be retained by the tracer.
+type StepAnnotator interface {
+	CaptureAnnotations(env *EVM, pc uint64, op OpCode, depth int, annotations map[string]interface{})
+}
+
+// BackEdgeTracer is an optional interface for tracers, notified whenever a JUMP
+// or JUMPI transfers control backwards, i.e. to a destination before itself.
+// Counting these traversals yields the iteration counts of the loops executed.
+type BackEdgeTracer interface {
+	CaptureBackEdge(env *EVM, from, to uint64, depth int)
+}
+
+// RefundTracer is an optional interface for tracers, notified of the change to
+// the refund counter made while charging the gas of a step, i.e. the refunds of
+// SSTORE and, before EIP-3529, SELFDESTRUCT. It's called right after CaptureState
+// for the steps changing the counter. Refunds rolled back by a failing call are
+// not reported.
+type RefundTracer interface {
+	CaptureRefund(env *EVM, pc uint64, op OpCode, depth int, delta int64)
+}
+
+// StackTracer is an optional interface for tracers, notified of the stack length
+// every step leaves behind once its opcode executed successfully. For calls and
+// creations, it's reported after the callee returned.
+type StackTracer interface {
+	CaptureStackLen(env *EVM, pc uint64, op OpCode, depth int, length int)
+}
+
+// CallSizeTracer is an optional interface for tracers, notified of the input
+// and output sizes of every message call once it returns, without the cost of
+// copying the data. The depth is the one of the callee's frame.
+type CallSizeTracer interface {
+	CaptureCallSizes(env *EVM, typ OpCode, from, to common.Address, depth int, inputSize, outputSize int)
+}
+
+// CreateTracer is an optional interface for tracers, notified whenever CREATE or
+// CREATE2 enters the initcode of a new contract, a fresh code context at the
+// computed address. The depth is the one of the initcode's frame.
+type CreateTracer interface {
+	CaptureEnterCreate(env *EVM, typ OpCode, from, to common.Address, depth int, gas uint64, value *big.Int)
+}
+
 // StructLogger is an EVM state logger and implements Tracer.
 //
 // StructLogger can capture state based on the given Log configuration and also keeps
@@ -118,10 +166,15 @@ type Tracer interface {
 type StructLogger struct {
 	cfg LogConfig
 
-	storage map[common.Address]Storage
-	logs    []StructLog
-	output  []byte
-	err     error
+	storage    map[common.Address]Storage
+	logs       []StructLog
+	finalStack []*big.Int
+	stackDepth []int
+	stackSteps []int // per call depth, the step whose stack length is observed next
+	output     []byte
+	err        error
+
+	stepLogged bool // whether the current step was logged, to attach its refund delta
 }
 
 // NewStructLogger returns a new logger
@@ -146,6 +199,17 @@ func (l *StructLogger) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost ui
 	memory := scope.Memory
 	stack := scope.Stack
 	contract := scope.Contract
+	l.stepLogged = false
+	// Snapshot the stack the top-level call halts with, regardless of the limit
+	if l.cfg.FinalStack && depth == 1 && (op == STOP || op == RETURN || op == REVERT) {
+		l.finalStack = make([]*big.Int, len(stack.Data()))
+		for i, item := range stack.Data() {
+			l.finalStack[i] = new(big.Int).Set(item.ToBig())
+		}
+	}
+	if l.cfg.StackDepths {
+		l.captureStackDepth(stack, depth, err)
+	}
 	// check if already accumulated the specified number of logs
 	if l.cfg.Limit != 0 && l.cfg.Limit <= len(l.logs) {
 		return
@@ -196,13 +260,58 @@ func (l *StructLogger) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost ui
 		copy(rdata, rData)
 	}
 	// create a new snapshot of the EVM.
-	log := StructLog{pc, op, gas, cost, mem, memory.Len(), stck, rdata, storage, depth, env.StateDB.GetRefund(), err}
+	log := StructLog{pc, op, gas, cost, mem, memory.Len(), stck, rdata, storage, depth, env.StateDB.GetRefund(), 0, err}
 	l.logs = append(l.logs, log)
+	l.stepLogged = true
+}
+
+// CaptureRefund implements RefundTracer, attaching the refund counter change to
+// the log of the step causing it.
+func (l *StructLogger) CaptureRefund(env *EVM, pc uint64, op OpCode, depth int, delta int64) {
+	if l.stepLogged {
+		l.logs[len(l.logs)-1].RefundDelta = delta
+	}
+}
+
+// captureStackDepth records the stack length of a step before its execution.
+// Unless the step already failed, it's replaced by CaptureStackLen once the
+// opcode was executed, or by CaptureFault if the execution fails.
+func (l *StructLogger) captureStackDepth(stack *Stack, depth int, err error) {
+	// Any deeper frames returned, their pending steps are final
+	for len(l.stackSteps) < depth {
+		l.stackSteps = append(l.stackSteps, -1)
+	}
+	l.stackSteps = l.stackSteps[:depth]
+
+	l.stackDepth = append(l.stackDepth, stack.len())
+	if err != nil {
+		l.stackSteps[depth-1] = -1
+	} else {
+		l.stackSteps[depth-1] = len(l.stackDepth) - 1
+	}
+}
+
+// CaptureStackLen implements StackTracer, recording the stack length the step
+// pending in the frame left behind.
+func (l *StructLogger) CaptureStackLen(env *EVM, pc uint64, op OpCode, depth int, length int) {
+	if l.cfg.StackDepths && depth <= len(l.stackSteps) {
+		if step := l.stackSteps[depth-1]; step >= 0 {
+			l.stackDepth[step] = length
+			l.stackSteps[depth-1] = -1
+		}
+	}
 }
 
 // CaptureFault implements the Tracer interface to trace an execution fault
 // while running an opcode.
 func (l *StructLogger) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, depth int, err error) {
+	// The failing step didn't complete, keep the stack it was left with
+	if l.cfg.StackDepths && depth <= len(l.stackSteps) {
+		if step := l.stackSteps[depth-1]; step >= 0 {
+			l.stackDepth[step] = scope.Stack.len()
+			l.stackSteps[depth-1] = -1
+		}
+	}
 }
 
 // CaptureEnd is called after the call finishes to finalize the tracing.
@@ -220,6 +329,14 @@ func (l *StructLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration
 // StructLogs returns the captured log entries.
 func (l *StructLogger) StructLogs() []StructLog { return l.logs }
 
+// FinalStack returns the stack contents at the STOP, RETURN or REVERT that
+// ended the top-level call, if LogConfig.FinalStack was set.
+func (l *StructLogger) FinalStack() []*big.Int { return l.finalStack }
+
+// StackDepths returns the stack length after each step across all call frames,
+// if LogConfig.StackDepths was set.
+func (l *StructLogger) StackDepths() []int { return l.stackDepth }
+
 // Error returns the VM error captured by the trace.
 func (l *StructLogger) Error() error { return l.err }
 
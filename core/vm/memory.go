@@ -61,9 +61,30 @@ func (m *Memory) Set32(offset uint64, val *uint256.Int) {
 	val.WriteToSlice(m.store[offset:])
 }
 
+// Copy copies size bytes from src to dst within the memory. The regions may
+// overlap, in which case the source is read as it was before the copy.
+func (m *Memory) Copy(dst, src, size uint64) {
+	if size == 0 {
+		return
+	}
+	// The store should be resized PRIOR to copying, covering both regions
+	copy(m.store[dst:dst+size], m.store[src:src+size])
+}
+
 // Resize resizes the memory to size
 func (m *Memory) Resize(size uint64) {
 	if uint64(m.Len()) < size {
+		// Memory is often expanded a word at a time (e.g. MSTOREs in a loop), so
+		// grow the capacity by doubling to amortise reallocation and copying.
+		if uint64(cap(m.store)) < size {
+			newCap := 2 * uint64(cap(m.store))
+			if newCap < size {
+				newCap = size
+			}
+			store := make([]byte, len(m.store), newCap)
+			copy(store, m.store)
+			m.store = store
+		}
 		m.store = append(m.store, make([]byte, size-uint64(m.Len()))...)
 	}
 }
@@ -36,6 +36,22 @@ func memoryExtCodeCopy(stack *Stack) (uint64, bool) {
 	return calcMemSize64(stack.Back(1), stack.Back(3))
 }
 
+func memoryMcopy(stack *Stack) (uint64, bool) {
+	// Both the source and the destination range need to be in memory
+	x, overflow := calcMemSize64(stack.Back(0), stack.Back(2))
+	if overflow {
+		return 0, true
+	}
+	y, overflow := calcMemSize64(stack.Back(1), stack.Back(2))
+	if overflow {
+		return 0, true
+	}
+	if x > y {
+		return x, false
+	}
+	return y, false
+}
+
 func memoryMLoad(stack *Stack) (uint64, bool) {
 	return calcMemSize64WithUint(stack.Back(0), 32)
 }
@@ -0,0 +1,59 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	// opcodeCounters track the number of times each opcode was executed by
+	// interpreters running with Config.OpcodeMetrics. They are registered on
+	// first use, under "vm/opcodes/<name>".
+	opcodeCounters     [256]metrics.Counter
+	opcodeCountersOnce sync.Once
+)
+
+// opcodeMetrics returns the execution counters of all opcodes, registering them
+// in the default metrics registry on first use. The counters are forced, since
+// Config.OpcodeMetrics is already an explicit opt-in.
+func opcodeMetrics() *[256]metrics.Counter {
+	opcodeCountersOnce.Do(func() {
+		for i := range opcodeCounters {
+			if name, ok := opCodeToString[OpCode(i)]; ok {
+				opcodeCounters[i] = metrics.GetOrRegisterCounterForced("vm/opcodes/"+name, nil)
+			} else {
+				opcodeCounters[i] = metrics.NilCounter{}
+			}
+		}
+	})
+	return &opcodeCounters
+}
+
+// sendOpcodeMetrics adds the opcodes executed by the interpreter since the last
+// call to the shared execution counters.
+func (in *EVMInterpreter) sendOpcodeMetrics() {
+	counters := opcodeMetrics()
+	for op, count := range in.opcodeStats {
+		if delta := count - in.opcodeSent[op]; delta > 0 {
+			counters[op].Inc(int64(delta))
+		}
+	}
+	*in.opcodeSent = *in.opcodeStats
+}
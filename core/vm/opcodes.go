@@ -103,6 +103,8 @@ const (
 	GASLIMIT
 	CHAINID     OpCode = 0x46
 	SELFBALANCE OpCode = 0x47
+	BLOBHASH    OpCode = 0x49
+	BLOBGASUSED OpCode = 0x4b
 )
 
 // 0x50 range - 'storage' and execution.
@@ -119,6 +121,7 @@ const (
 	MSIZE    OpCode = 0x59
 	GAS      OpCode = 0x5a
 	JUMPDEST OpCode = 0x5b
+	MCOPY    OpCode = 0x5e
 )
 
 // 0x60 range.
@@ -280,6 +283,8 @@ var opCodeToString = map[OpCode]string{
 	GASLIMIT:    "GASLIMIT",
 	CHAINID:     "CHAINID",
 	SELFBALANCE: "SELFBALANCE",
+	BLOBHASH:    "BLOBHASH",
+	BLOBGASUSED: "BLOBGASUSED",
 
 	// 0x50 range - 'storage' and execution.
 	POP: "POP",
@@ -296,6 +301,7 @@ var opCodeToString = map[OpCode]string{
 	MSIZE:    "MSIZE",
 	GAS:      "GAS",
 	JUMPDEST: "JUMPDEST",
+	MCOPY:    "MCOPY",
 
 	// 0x60 range - push.
 	PUSH1:  "PUSH1",
@@ -449,6 +455,8 @@ var stringToOp = map[string]OpCode{
 	"DIFFICULTY":     DIFFICULTY,
 	"GASLIMIT":       GASLIMIT,
 	"SELFBALANCE":    SELFBALANCE,
+	"BLOBHASH":       BLOBHASH,
+	"BLOBGASUSED":    BLOBGASUSED,
 	"POP":            POP,
 	"MLOAD":          MLOAD,
 	"MSTORE":         MSTORE,
@@ -461,6 +469,7 @@ var stringToOp = map[string]OpCode{
 	"MSIZE":          MSIZE,
 	"GAS":            GAS,
 	"JUMPDEST":       JUMPDEST,
+	"MCOPY":          MCOPY,
 	"PUSH1":          PUSH1,
 	"PUSH2":          PUSH2,
 	"PUSH3":          PUSH3,
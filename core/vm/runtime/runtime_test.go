@@ -17,9 +17,11 @@
 package runtime
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -688,3 +690,362 @@ func TestColdAccountAccessCost(t *testing.T) {
 		}
 	}
 }
+
+// TestFinalStackCapture checks that the struct logger exposes the stack left
+// behind when the top-level call halts.
+func TestFinalStackCapture(t *testing.T) {
+	code := []byte{
+		byte(vm.PUSH1), 0x01,
+		byte(vm.PUSH1), 0x02,
+		byte(vm.STOP),
+	}
+	tracer := vm.NewStructLogger(&vm.LogConfig{FinalStack: true})
+	if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{Debug: true, Tracer: tracer}}); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	stack := tracer.FinalStack()
+	if len(stack) != 2 {
+		t.Fatalf("final stack length mismatch: have %d, want 2", len(stack))
+	}
+	if stack[0].Uint64() != 1 || stack[1].Uint64() != 2 {
+		t.Errorf("final stack mismatch: have %v, want [1 2]", stack)
+	}
+}
+
+// memoryFillCode returns a loop writing one word per iteration at increasing
+// offsets, growing memory one word at a time up to the given number of words.
+func memoryFillCode(words uint16) []byte {
+	limit := words * 32
+	return []byte{
+		byte(vm.PUSH1), 0, // [ offset ]
+		byte(vm.JUMPDEST),
+		byte(vm.DUP1), byte(vm.DUP1), byte(vm.MSTORE), // mstore(offset, offset)
+		byte(vm.PUSH1), 32, byte(vm.ADD), // offset += 32
+		byte(vm.DUP1), byte(vm.PUSH2), byte(limit >> 8), byte(limit),
+		byte(vm.GT), byte(vm.PUSH1), 2, byte(vm.JUMPI), // loop while limit > offset
+		byte(vm.STOP),
+	}
+}
+
+// TestMemoryFillGas checks that word-by-word memory growth is charged exactly
+// the linear and quadratic expansion cost for the final size.
+func TestMemoryFillGas(t *testing.T) {
+	for _, words := range []uint64{1, 32, 1024, 2047} {
+		var (
+			cfg     = &Config{GasLimit: 10_000_000}
+			address = common.BytesToAddress([]byte("contract"))
+		)
+		cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		cfg.State.SetCode(address, memoryFillCode(uint16(words)))
+		if _, left, err := Call(address, nil, cfg); err != nil {
+			t.Fatalf("words %d: execution failed: %v", words, err)
+		} else {
+			// PUSH1 + per iteration: JUMPDEST, 2xDUP1, MSTORE, PUSH1, ADD, DUP1, PUSH2, GT, PUSH1, JUMPI
+			var (
+				static = 3 + words*(1+3+3+3+3+3+3+3+3+3+10)
+				memory = words*params.MemoryGas + words*words/params.QuadCoeffDiv
+			)
+			if used := cfg.GasLimit - left; used != static+memory {
+				t.Errorf("words %d: gas used mismatch: have %d, want %d", words, used, static+memory)
+			}
+		}
+	}
+}
+
+func BenchmarkMemoryFill(b *testing.B) {
+	benchmarkNonModifyingCode(10_000_000, memoryFillCode(1024), "mstore-fill-32k", b)
+	benchmarkNonModifyingCode(10_000_000, memoryFillCode(2047), "mstore-fill-64k", b)
+}
+
+// TestCreateGasOverride checks that the chain config can lower the base cost of
+// CREATE and CREATE2 without affecting chains using the protocol defaults.
+func TestCreateGasOverride(t *testing.T) {
+	code := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.CREATE), byte(vm.POP),
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.CREATE2), byte(vm.POP),
+	}
+	config := *params.AllEthashProtocolChanges
+	config.GasCosts = &params.GasCostConfig{
+		Block:      new(big.Int),
+		CreateGas:  params.CreateGas / 2,
+		Create2Gas: params.Create2Gas / 2,
+	}
+	for i, tt := range []struct {
+		config  *params.ChainConfig
+		create  uint64
+		create2 uint64
+	}{
+		{&config, params.CreateGas / 2, params.Create2Gas / 2},
+		{params.AllEthashProtocolChanges, params.CreateGas, params.Create2Gas},
+	} {
+		tracer := vm.NewStructLogger(nil)
+		_, _, err := Execute(code, nil, &Config{
+			ChainConfig: tt.config,
+			EVMConfig:   vm.Config{Debug: true, Tracer: tracer},
+		})
+		if err != nil {
+			t.Fatalf("test %d: execution failed: %v", i, err)
+		}
+		for _, log := range tracer.StructLogs() {
+			if log.Depth != 1 {
+				continue
+			}
+			switch log.Op {
+			case vm.CREATE:
+				if log.GasCost != tt.create {
+					t.Errorf("test %d: CREATE gas mismatch: have %d, want %d", i, log.GasCost, tt.create)
+				}
+			case vm.CREATE2:
+				// CREATE2 additionally charges for hashing the (empty) init code
+				if log.GasCost != tt.create2 {
+					t.Errorf("test %d: CREATE2 gas mismatch: have %d, want %d", i, log.GasCost, tt.create2)
+				}
+			}
+		}
+	}
+}
+
+// TestStackDepthCapture checks that the struct logger records the stack length
+// after every step, growing with the pushes and shrinking with the pops, up to
+// and including the final RETURN.
+func TestStackDepthCapture(t *testing.T) {
+	code := []byte{
+		byte(vm.PUSH1), 0x01,
+		byte(vm.PUSH1), 0x02,
+		byte(vm.PUSH1), 0x03,
+		byte(vm.POP),
+		byte(vm.ADD),
+		byte(vm.PUSH1), 0x00,
+		byte(vm.DUP1),
+		byte(vm.RETURN),
+	}
+	tracer := vm.NewStructLogger(&vm.LogConfig{StackDepths: true, Limit: 1})
+	if _, _, err := Execute(code, nil, &Config{EVMConfig: vm.Config{Debug: true, Tracer: tracer}}); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	want := []int{1, 2, 3, 2, 1, 2, 3, 1}
+	if have := tracer.StackDepths(); !reflect.DeepEqual(have, want) {
+		t.Errorf("stack depths mismatch: have %v, want %v", have, want)
+	}
+}
+
+// TestStackDepthCaptureCall checks that the stack length after a call is taken
+// from the calling frame, and the callee's last step from the callee's frame.
+func TestStackDepthCaptureCall(t *testing.T) {
+	var (
+		cfg    = new(Config)
+		callee = common.BytesToAddress([]byte{0xcc})
+	)
+	cfg.State, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	cfg.State.SetCode(callee, []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 2, byte(vm.STOP)})
+
+	code := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+		byte(vm.PUSH1), 0xcc, byte(vm.GAS), byte(vm.CALL),
+		byte(vm.POP), byte(vm.STOP),
+	}
+	tracer := vm.NewStructLogger(&vm.LogConfig{StackDepths: true})
+	cfg.EVMConfig = vm.Config{Debug: true, Tracer: tracer}
+	if _, _, err := Execute(code, nil, cfg); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	want := []int{1, 2, 3, 4, 5, 6, 7, 1, 1, 2, 2, 0, 0}
+	if have := tracer.StackDepths(); !reflect.DeepEqual(have, want) {
+		t.Errorf("stack depths mismatch: have %v, want %v", have, want)
+	}
+}
+
+// failingPrecompile is a precompile that fails with partial output.
+type failingPrecompile struct{}
+
+func (failingPrecompile) RequiredGas(input []byte) uint64 { return 100 }
+
+func (failingPrecompile) Run(input []byte) ([]byte, error) {
+	return []byte{0xde, 0xad, 0xbe, 0xef}, errors.New("precompile failed")
+}
+
+// TestPrecompileFailureReturnData checks that a failing precompile call leaves
+// the return data buffer of the caller empty, even if the precompile produced
+// some output before failing.
+func TestPrecompileFailureReturnData(t *testing.T) {
+	if ret, gas, err := vm.RunPrecompiledContract(failingPrecompile{}, nil, 1000); err == nil || ret != nil || gas != 900 {
+		t.Fatalf("precompile result mismatch: have (%x, %d, %v), want (nil, 900, failure)", ret, gas, err)
+	}
+	code := []byte{
+		// Call the bn256 pairing precompile with a truncated pair, reserving
+		// room for its output
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0, // retSize, retOffset
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, // inSize, inOffset
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0x08, // value, address
+		byte(vm.GAS), byte(vm.CALL),
+		// Return [returndatasize, success]
+		byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.RETURNDATASIZE), byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0, byte(vm.RETURN),
+	}
+	ret, _, err := Execute(code, nil, nil)
+	if err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if size := new(big.Int).SetBytes(ret[:32]); size.Sign() != 0 {
+		t.Errorf("return data size mismatch: have %v, want 0", size)
+	}
+	if success := new(big.Int).SetBytes(ret[32:]); success.Sign() != 0 {
+		t.Errorf("expected precompile call to fail")
+	}
+}
+
+// TestSha3WordGasOverride checks that the chain config can change the per word
+// cost of the data hashed by SHA3, and of the initcode hashed by CREATE2.
+func TestSha3WordGasOverride(t *testing.T) {
+	config := *params.AllEthashProtocolChanges
+	config.GasCosts = &params.GasCostConfig{Block: new(big.Int), Sha3WordGas: 2 * params.Sha3WordGas}
+
+	for _, tt := range []struct {
+		name string
+		code []byte
+		step int
+		want uint64
+	}{
+		{
+			name: "SHA3",
+			code: []byte{
+				byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0, byte(vm.SHA3), // sha3(0, 64)
+				byte(vm.STOP),
+			},
+			step: 2,
+			// Base cost, two words of hashed data and two words of memory expansion
+			want: params.Sha3Gas + 2*2*params.Sha3WordGas + 2*params.MemoryGas,
+		},
+		{
+			name: "CREATE2",
+			code: []byte{
+				byte(vm.PUSH1), 0, byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+				byte(vm.CREATE2), // create2(0, 0, 64, 0)
+				byte(vm.STOP),
+			},
+			step: 4,
+			// Base cost, two words of hashed initcode and two words of memory expansion
+			want: params.Create2Gas + 2*2*params.Sha3WordGas + 2*params.MemoryGas,
+		},
+	} {
+		tracer := vm.NewStructLogger(nil)
+		_, _, err := Execute(tt.code, nil, &Config{
+			ChainConfig: &config,
+			EVMConfig:   vm.Config{Debug: true, Tracer: tracer},
+		})
+		if err != nil {
+			t.Fatalf("%s: execution failed: %v", tt.name, err)
+		}
+		if have := tracer.StructLogs()[tt.step].GasCost; have != tt.want {
+			t.Errorf("%s: gas mismatch: have %d, want %d", tt.name, have, tt.want)
+		}
+	}
+}
+
+// TestLogDataGasOverride checks that the chain config can change the per byte
+// cost of the data logged by the LOG opcodes.
+func TestLogDataGasOverride(t *testing.T) {
+	code := []byte{
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0, byte(vm.LOG1), // log1(0, 32, 1)
+		byte(vm.STOP),
+	}
+	config := *params.AllEthashProtocolChanges
+	config.GasCosts = &params.GasCostConfig{Block: new(big.Int), LogDataGas: 2 * params.LogDataGas}
+
+	tracer := vm.NewStructLogger(nil)
+	_, _, err := Execute(code, nil, &Config{
+		ChainConfig: &config,
+		EVMConfig:   vm.Config{Debug: true, Tracer: tracer},
+	})
+	if err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	// Base cost, one topic, 32 bytes of logged data and one word of memory expansion
+	want := params.LogGas + params.LogTopicGas + 32*2*params.LogDataGas + params.MemoryGas
+	if have := tracer.StructLogs()[3].GasCost; have != want {
+		t.Errorf("LOG1 gas mismatch: have %d, want %d", have, want)
+	}
+}
+
+// TestRefundDeltaCapture checks that the struct logger attributes refund counter
+// changes to the steps causing them.
+func TestRefundDeltaCapture(t *testing.T) {
+	var (
+		address    = common.BytesToAddress([]byte("contract"))
+		statedb, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	)
+	statedb.SetCode(address, []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.SSTORE), // clear the slot
+		byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE), // reset it to its original value
+		byte(vm.STOP),
+	})
+	statedb.SetState(address, common.Hash{}, common.BytesToHash([]byte{1}))
+	statedb.Finalise(false) // Push the state into the "original" slot
+
+	tracer := vm.NewStructLogger(nil)
+	_, _, err := Call(address, nil, &Config{
+		State:     statedb,
+		EVMConfig: vm.Config{Debug: true, Tracer: tracer},
+	})
+	if err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	var (
+		clear = int64(params.SstoreClearsScheduleRefundEIP2200)
+		reset = int64(params.SstoreResetGasEIP2200 - vm.ColdSloadCostEIP2929 - vm.WarmStorageReadCostEIP2929)
+		want  = []int64{0, 0, clear, 0, 0, reset - clear, 0}
+	)
+	logs := tracer.StructLogs()
+	if len(logs) != len(want) {
+		t.Fatalf("step count mismatch: have %d, want %d", len(logs), len(want))
+	}
+	for i, log := range logs {
+		if log.RefundDelta != want[i] {
+			t.Errorf("step %d (%v): refund delta mismatch: have %d, want %d", i, log.Op, log.RefundDelta, want[i])
+		}
+	}
+}
+
+// TestEIP161EmptyAccounts checks that a zero value CALL does not create a
+// missing account, and that touched empty accounts are reported and deleted at
+// the end of the transaction.
+func TestEIP161EmptyAccounts(t *testing.T) {
+	var (
+		missing = common.HexToAddress("0xdead")
+		empty   = common.HexToAddress("0xbeef")
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.CreateAccount(empty)
+	statedb.Finalise(false)
+
+	call := func(addr common.Address) []byte {
+		return []byte{
+			byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, // ret and args
+			byte(vm.PUSH1), 0, // value
+			byte(vm.PUSH2), addr[18], addr[19],
+			byte(vm.GAS), byte(vm.CALL), byte(vm.POP),
+		}
+	}
+	code := append(call(missing), call(empty)...)
+	if _, _, err := Execute(code, nil, &Config{State: statedb}); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if statedb.Exist(missing) {
+		t.Errorf("zero value call created missing account")
+	}
+	// The (empty) origin is touched as well by the call into the code
+	touched := statedb.TouchedEmptyAccounts()
+	if want := []common.Address{{}, empty}; !reflect.DeepEqual(touched, want) {
+		t.Errorf("touched empty accounts mismatch: have %x, want %x", touched, want)
+	}
+	statedb.Finalise(true)
+	if statedb.Exist(empty) || statedb.Exist(missing) {
+		t.Errorf("empty accounts not deleted at the end of the transaction")
+	}
+	if touched := statedb.TouchedEmptyAccounts(); len(touched) != 0 {
+		t.Errorf("touched empty accounts not cleared: %x", touched)
+	}
+}
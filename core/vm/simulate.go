@@ -0,0 +1,132 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// StateDiff is the set of state changes a simulated call would have made,
+// holding the post-execution values of the modified accounts and slots.
+type StateDiff struct {
+	Balances map[common.Address]*big.Int
+	Nonces   map[common.Address]uint64
+	Storage  map[common.Address]map[common.Hash]common.Hash
+}
+
+// SimulateCall executes the call like Call does, but reverts all its state
+// changes afterwards, returning them as a diff instead. Writes reverted during
+// the execution itself, e.g. by failing sub calls, are not part of the diff.
+func (evm *EVM) SimulateCall(caller ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, diff *StateDiff, err error) {
+	recorder := &writeRecorder{
+		StateDB:  evm.StateDB,
+		balances: make(map[common.Address]*big.Int),
+		nonces:   make(map[common.Address]uint64),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+	}
+	snapshot := recorder.StateDB.Snapshot()
+
+	evm.StateDB = recorder
+	defer func() { evm.StateDB = recorder.StateDB }()
+
+	ret, leftOverGas, err = evm.Call(caller, addr, input, gas, value)
+	diff = recorder.diff()
+	recorder.StateDB.RevertToSnapshot(snapshot)
+	return ret, leftOverGas, diff, err
+}
+
+// writeRecorder is a StateDB recording the original values of the balances,
+// nonces and storage slots written to it.
+type writeRecorder struct {
+	StateDB
+	balances map[common.Address]*big.Int
+	nonces   map[common.Address]uint64
+	storage  map[common.Address]map[common.Hash]common.Hash
+}
+
+func (r *writeRecorder) recordBalance(addr common.Address) {
+	if _, ok := r.balances[addr]; !ok {
+		r.balances[addr] = new(big.Int).Set(r.StateDB.GetBalance(addr))
+	}
+}
+
+func (r *writeRecorder) SubBalance(addr common.Address, amount *big.Int) {
+	r.recordBalance(addr)
+	r.StateDB.SubBalance(addr, amount)
+}
+
+func (r *writeRecorder) AddBalance(addr common.Address, amount *big.Int) {
+	r.recordBalance(addr)
+	r.StateDB.AddBalance(addr, amount)
+}
+
+func (r *writeRecorder) Suicide(addr common.Address) bool {
+	r.recordBalance(addr)
+	return r.StateDB.Suicide(addr)
+}
+
+func (r *writeRecorder) SetNonce(addr common.Address, nonce uint64) {
+	if _, ok := r.nonces[addr]; !ok {
+		r.nonces[addr] = r.StateDB.GetNonce(addr)
+	}
+	r.StateDB.SetNonce(addr, nonce)
+}
+
+func (r *writeRecorder) SetState(addr common.Address, key, value common.Hash) {
+	slots := r.storage[addr]
+	if slots == nil {
+		slots = make(map[common.Hash]common.Hash)
+		r.storage[addr] = slots
+	}
+	if _, ok := slots[key]; !ok {
+		slots[key] = r.StateDB.GetState(addr, key)
+	}
+	r.StateDB.SetState(addr, key, value)
+}
+
+// diff returns the current values of the recorded balances, nonces and slots
+// which differ from their original ones.
+func (r *writeRecorder) diff() *StateDiff {
+	diff := &StateDiff{
+		Balances: make(map[common.Address]*big.Int),
+		Nonces:   make(map[common.Address]uint64),
+		Storage:  make(map[common.Address]map[common.Hash]common.Hash),
+	}
+	for addr, prev := range r.balances {
+		if balance := r.StateDB.GetBalance(addr); balance.Cmp(prev) != 0 {
+			diff.Balances[addr] = new(big.Int).Set(balance)
+		}
+	}
+	for addr, prev := range r.nonces {
+		if nonce := r.StateDB.GetNonce(addr); nonce != prev {
+			diff.Nonces[addr] = nonce
+		}
+	}
+	for addr, slots := range r.storage {
+		for key, prev := range slots {
+			if value := r.StateDB.GetState(addr, key); value != prev {
+				if diff.Storage[addr] == nil {
+					diff.Storage[addr] = make(map[common.Hash]common.Hash)
+				}
+				diff.Storage[addr][key] = value
+			}
+		}
+	}
+	return diff
+}
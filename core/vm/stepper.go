@@ -0,0 +1,194 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// Stepper executes a message call one opcode at a time, pausing the interpreter
+// before every opcode so its state can be inspected, e.g. by interactive
+// debuggers.
+//
+// The call runs on a separate goroutine which is blocked while the stepper is
+// paused, so at most one of the caller and the EVM ever touches the state. The
+// stepper must either be stepped until the call is done or be closed, otherwise
+// the goroutine is leaked.
+type Stepper struct {
+	evm  *EVM
+	call func() ([]byte, uint64, error)
+
+	paused   chan struct{} // Signalled by the EVM when it pauses before an opcode
+	resume   chan struct{} // Signalled by the caller to execute the paused opcode
+	abort    chan struct{} // Closed by the caller to abort the call
+	finished chan struct{} // Closed by the EVM when the call is done
+
+	started bool
+	done    bool
+
+	// State of the paused step
+	pc     uint64
+	op     OpCode
+	gas    uint64
+	depth  int
+	stack  []uint256.Int
+	memory []byte
+
+	// Results of the finished call
+	ret         []byte
+	leftOverGas uint64
+	err         error
+}
+
+// NewStepper returns a stepper for a message call to the given address. While
+// the call is being stepped through, the stepper replaces the tracing
+// configuration of the EVM, which must not be used for anything else until the
+// stepper is done or closed. Like any EVM, it's meant to execute a single call.
+func (evm *EVM) NewStepper(caller ContractRef, addr common.Address, input []byte, gas uint64, value *big.Int) *Stepper {
+	s := &Stepper{
+		evm:      evm,
+		paused:   make(chan struct{}),
+		resume:   make(chan struct{}),
+		abort:    make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	s.call = func() ([]byte, uint64, error) {
+		// Trace the call with the stepper, restoring the configuration afterwards
+		debug, tracer := evm.setTracer(true, s)
+		defer func() {
+			evm.setTracer(debug, tracer)
+			evm.stepAborted = false
+		}()
+
+		return evm.Call(caller, addr, input, gas, value)
+	}
+	return s
+}
+
+// setTracer changes the tracing configuration of the EVM and its interpreters,
+// returning the previous one.
+func (evm *EVM) setTracer(debug bool, tracer Tracer) (bool, Tracer) {
+	prevDebug, prevTracer := evm.vmConfig.Debug, evm.vmConfig.Tracer
+	evm.vmConfig.Debug, evm.vmConfig.Tracer = debug, tracer
+	for _, interpreter := range evm.interpreters {
+		if in, ok := interpreter.(*EVMInterpreter); ok {
+			in.cfg.Debug, in.cfg.Tracer = debug, tracer
+		}
+	}
+	return prevDebug, prevTracer
+}
+
+// Step advances the execution to right before the next opcode, or to the end of
+// the call if there are no more opcodes to execute. The first step starts the
+// call and pauses before its first opcode.
+func (s *Stepper) Step() (done bool, err error) {
+	if s.done {
+		return true, s.err
+	}
+	if !s.started {
+		s.started = true
+		go func() {
+			s.ret, s.leftOverGas, s.err = s.call()
+			close(s.finished)
+		}()
+	} else {
+		s.resume <- struct{}{}
+	}
+	select {
+	case <-s.paused:
+		return false, nil
+	case <-s.finished:
+		s.done = true
+		return true, s.err
+	}
+}
+
+// Close aborts the call if it is still being stepped through and waits for the
+// EVM to finish. The paused opcode is not executed, and all frames of the call
+// fail with ErrExecutionAborted, which reverts their state changes.
+func (s *Stepper) Close() {
+	if !s.started || s.done {
+		s.done = true
+		return
+	}
+	close(s.abort)
+	<-s.finished
+	s.done = true
+}
+
+// PC returns the program counter of the opcode about to be executed.
+func (s *Stepper) PC() uint64 { return s.pc }
+
+// Op returns the opcode about to be executed.
+func (s *Stepper) Op() OpCode { return s.op }
+
+// Gas returns the gas available before the opcode about to be executed.
+func (s *Stepper) Gas() uint64 { return s.gas }
+
+// Depth returns the call depth of the opcode about to be executed.
+func (s *Stepper) Depth() int { return s.depth }
+
+// Stack returns a copy of the stack before the opcode about to be executed, with
+// the top of the stack as the last element.
+func (s *Stepper) Stack() []uint256.Int { return s.stack }
+
+// Memory returns a copy of the memory before the opcode about to be executed.
+func (s *Stepper) Memory() []byte { return s.memory }
+
+// Result returns the return data and leftover gas of the finished call.
+func (s *Stepper) Result() ([]byte, uint64, error) { return s.ret, s.leftOverGas, s.err }
+
+// CaptureStart implements Tracer.
+func (s *Stepper) CaptureStart(env *EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+// CaptureState implements Tracer, pausing the EVM until the next step. Once the
+// stepper is closed, it flags the EVM as aborted instead, making the interpreter
+// fail every frame before executing its next opcode.
+func (s *Stepper) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, rData []byte, depth int, err error) {
+	// Failed steps are reported again after the fact, don't pause on those
+	if err != nil {
+		return
+	}
+	select {
+	case <-s.abort:
+		env.stepAborted = true
+		return
+	default:
+	}
+	s.pc, s.op, s.gas, s.depth = pc, op, gas, depth
+	s.stack = append(s.stack[:0:0], scope.Stack.Data()...)
+	s.memory = common.CopyBytes(scope.Memory.Data())
+
+	s.paused <- struct{}{}
+	select {
+	case <-s.resume:
+	case <-s.abort:
+		env.stepAborted = true
+	}
+}
+
+// CaptureFault implements Tracer.
+func (s *Stepper) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, depth int, err error) {
+}
+
+// CaptureEnd implements Tracer.
+func (s *Stepper) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) {}
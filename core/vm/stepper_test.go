@@ -0,0 +1,137 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestStepper(t *testing.T) {
+	evm, address := newTestEVM([]byte{
+		byte(PUSH1), 1,
+		byte(PUSH1), 2,
+		byte(ADD),
+		byte(STOP),
+	}, Config{})
+	stepper := evm.NewStepper(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+
+	tests := []struct {
+		pc    uint64
+		op    OpCode
+		stack []uint64
+	}{
+		{0, PUSH1, nil},
+		{2, PUSH1, []uint64{1}},
+		{4, ADD, []uint64{1, 2}},
+		{5, STOP, []uint64{3}},
+	}
+	for i, tt := range tests {
+		done, err := stepper.Step()
+		if done || err != nil {
+			t.Fatalf("step %d: unexpected end of execution: %v", i, err)
+		}
+		if stepper.PC() != tt.pc || stepper.Op() != tt.op {
+			t.Errorf("step %d: position mismatch: have %d/%v, want %d/%v", i, stepper.PC(), stepper.Op(), tt.pc, tt.op)
+		}
+		stack := stepper.Stack()
+		if len(stack) != len(tt.stack) {
+			t.Fatalf("step %d: stack length mismatch: have %d, want %d", i, len(stack), len(tt.stack))
+		}
+		for j, want := range tt.stack {
+			if stack[j].Uint64() != want {
+				t.Errorf("step %d: stack item %d mismatch: have %v, want %d", i, j, &stack[j], want)
+			}
+		}
+	}
+	if done, err := stepper.Step(); !done || err != nil {
+		t.Fatalf("execution not finished: done %v, err %v", done, err)
+	}
+	if _, gas, _ := stepper.Result(); gas != 100000-3*GasFastestStep {
+		t.Errorf("leftover gas mismatch: have %d, want %d", gas, 100000-3*GasFastestStep)
+	}
+	if evm.vmConfig.Debug || evm.vmConfig.Tracer != nil {
+		t.Errorf("tracing configuration not restored: debug %v, tracer %v", evm.vmConfig.Debug, evm.vmConfig.Tracer)
+	}
+}
+
+func TestStepperClose(t *testing.T) {
+	// An infinite loop: JUMPDEST, PUSH1 0, JUMP
+	evm, address := newTestEVM([]byte{byte(JUMPDEST), byte(PUSH1), 0, byte(JUMP)}, Config{})
+	stepper := evm.NewStepper(AccountRef(common.Address{}), address, nil, 100000000, new(big.Int))
+
+	for i := 0; i < 10; i++ {
+		if done, err := stepper.Step(); done {
+			t.Fatalf("step %d: unexpected end of execution: %v", i, err)
+		}
+	}
+	stepper.Close()
+	if done, _ := stepper.Step(); !done {
+		t.Fatal("execution not finished after close")
+	}
+	// The call is aborted, leaving the EVM itself intact
+	if _, _, err := stepper.Result(); err != ErrExecutionAborted {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrExecutionAborted)
+	}
+	if evm.Cancelled() {
+		t.Error("EVM cancelled by closing the stepper")
+	}
+	if evm.vmConfig.Debug || evm.vmConfig.Tracer != nil {
+		t.Errorf("tracing configuration not restored: debug %v, tracer %v", evm.vmConfig.Debug, evm.vmConfig.Tracer)
+	}
+}
+
+// TestStepperCloseReverts checks that closing a stepper paused before an SSTORE
+// doesn't execute it, reverting the call instead.
+func TestStepperCloseReverts(t *testing.T) {
+	// sstore(0, 1), followed by a free STOP
+	evm, address := newTestEVM([]byte{byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE), byte(STOP)}, Config{})
+	evm.StateDB.AddAddressToAccessList(address)
+	stepper := evm.NewStepper(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+
+	for stepper.Op() != SSTORE {
+		if done, err := stepper.Step(); done {
+			t.Fatalf("execution finished before SSTORE: %v", err)
+		}
+	}
+	stepper.Close()
+	if _, _, err := stepper.Result(); err != ErrExecutionAborted {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrExecutionAborted)
+	}
+	if have := evm.StateDB.GetState(address, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("aborted SSTORE committed: slot 0 is %x", have)
+	}
+	// The EVM can run further calls once the stepper is closed
+	if _, _, err := evm.Call(AccountRef(common.Address{}), address, nil, 100000, new(big.Int)); err != nil {
+		t.Errorf("call after close failed: %v", err)
+	}
+}
+
+func TestStepperCloseUnstarted(t *testing.T) {
+	evm, address := newTestEVM([]byte{byte(STOP)}, Config{})
+	stepper := evm.NewStepper(AccountRef(common.Address{}), address, nil, 100000, new(big.Int))
+	stepper.Close()
+
+	if done, _ := stepper.Step(); !done {
+		t.Fatal("closed stepper started execution")
+	}
+	if evm.vmConfig.Debug || evm.vmConfig.Tracer != nil {
+		t.Errorf("tracing configuration changed: debug %v, tracer %v", evm.vmConfig.Debug, evm.vmConfig.Tracer)
+	}
+}
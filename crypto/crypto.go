@@ -116,6 +116,31 @@ func CreateAddress2(b common.Address, salt [32]byte, inithash []byte) common.Add
 	return common.BytesToAddress(Keccak256([]byte{0xff}, b.Bytes(), salt[:], inithash)[12:])
 }
 
+// PredictCreate2Addresses derives the CREATE2 addresses of the same initcode
+// deployed by the given creator for each of the salts. The hash preimage is
+// shared across all salts, only patching in the salt, and a single hasher is
+// reused, avoiding any per-salt allocations besides the result.
+func PredictCreate2Addresses(creator common.Address, salts [][32]byte, initCodeHash common.Hash) []common.Address {
+	var (
+		preimage = make([]byte, 1+common.AddressLength+32+common.HashLength)
+		hasher   = NewKeccakState()
+		addrs    = make([]common.Address, len(salts))
+		hash     = make([]byte, common.HashLength)
+	)
+	preimage[0] = 0xff
+	copy(preimage[1:], creator.Bytes())
+	copy(preimage[1+common.AddressLength+32:], initCodeHash.Bytes())
+
+	for i, salt := range salts {
+		copy(preimage[1+common.AddressLength:], salt[:])
+		hasher.Reset()
+		hasher.Write(preimage)
+		hasher.Read(hash)
+		copy(addrs[i][:], hash[12:])
+	}
+	return addrs
+}
+
 // ToECDSA creates a private key with the given D value.
 func ToECDSA(d []byte) (*ecdsa.PrivateKey, error) {
 	return toECDSA(d, true)
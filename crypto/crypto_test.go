@@ -65,6 +65,22 @@ func BenchmarkSha3(b *testing.B) {
 	}
 }
 
+func BenchmarkPredictCreate2Addresses(b *testing.B) {
+	var (
+		creator  = common.HexToAddress(testAddrHex)
+		initHash = Keccak256Hash([]byte{0x00})
+		salts    = make([][32]byte, 10000)
+	)
+	for i := range salts {
+		salts[i][30], salts[i][31] = byte(i>>8), byte(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		PredictCreate2Addresses(creator, salts, initHash)
+	}
+}
+
 func TestUnmarshalPubkey(t *testing.T) {
 	key, err := UnmarshalPubkey(nil)
 	if err != errInvalidPubkey || key != nil {
@@ -146,6 +162,27 @@ func TestNewContractAddress(t *testing.T) {
 	checkAddr(t, common.HexToAddress("c9ddedf451bc62ce88bf9292afb13df35b670699"), caddr2)
 }
 
+func TestPredictCreate2Addresses(t *testing.T) {
+	var (
+		creator  = common.HexToAddress(testAddrHex)
+		initHash = Keccak256Hash([]byte{0x00})
+		salts    = make([][32]byte, 100)
+	)
+	for i := range salts {
+		salts[i][31], salts[i][0] = byte(i), byte(i*7)
+	}
+	addrs := PredictCreate2Addresses(creator, salts, initHash)
+	if len(addrs) != len(salts) {
+		t.Fatalf("address count mismatch: have %d, want %d", len(addrs), len(salts))
+	}
+	for i, salt := range salts {
+		checkAddr(t, CreateAddress2(creator, salt, initHash.Bytes()), addrs[i])
+	}
+	// Example 0 of EIP-1014
+	addrs = PredictCreate2Addresses(common.Address{}, [][32]byte{{}}, initHash)
+	checkAddr(t, common.HexToAddress("4D1A2e2bB4F88F0250f26Ffff098B0b30B26BF38"), addrs[0])
+}
+
 func TestLoadECDSA(t *testing.T) {
 	tests := []struct {
 		input string
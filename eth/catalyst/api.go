@@ -71,6 +71,7 @@ type blockExecutionEnv struct {
 	state   *state.StateDB
 	tcount  int
 	gasPool *core.GasPool
+	blobGas uint64
 
 	header   *types.Header
 	txs      []*types.Transaction
@@ -79,7 +80,7 @@ type blockExecutionEnv struct {
 
 func (env *blockExecutionEnv) commitTransaction(tx *types.Transaction, coinbase common.Address) error {
 	vmconfig := *env.chain.GetVMConfig()
-	receipt, err := core.ApplyTransaction(env.chain.Config(), env.chain, &coinbase, env.gasPool, env.state, env.header, tx, &env.header.GasUsed, vmconfig)
+	receipt, err := core.ApplyTransactionWithBlobGas(env.chain.Config(), env.chain, &coinbase, env.gasPool, env.state, env.header, tx, &env.header.GasUsed, &env.blobGas, vmconfig)
 	if err != nil {
 		return err
 	}
@@ -244,16 +244,16 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil, nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil, nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -333,6 +333,10 @@ type ChainConfig struct {
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
+
+	// Gas cost overrides, e.g. for L2s subsidizing certain operations (nil = protocol defaults)
+	// from their activation block on
+	GasCosts *GasCostConfig `json:"gasCosts,omitempty"`
 }
 
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
@@ -354,6 +358,25 @@ func (c *CliqueConfig) String() string {
 	return "clique"
 }
 
+// GasCostConfig overrides selected protocol gas costs from its activation block
+// on. Fields left at zero keep the protocol default.
+type GasCostConfig struct {
+	Block *big.Int `json:"block,omitempty"` // Activation block of the overrides (nil = never, 0 = from genesis)
+
+	CreateGas   uint64 `json:"createGas,omitempty"`   // Base cost of the CREATE opcode
+	Create2Gas  uint64 `json:"create2Gas,omitempty"`  // Base cost of the CREATE2 opcode
+	Sha3WordGas uint64 `json:"sha3WordGas,omitempty"` // Per word cost of the data hashed by SHA3 and CREATE2
+
+	LogGas      uint64 `json:"logGas,omitempty"`      // Base cost of the LOG opcodes
+	LogTopicGas uint64 `json:"logTopicGas,omitempty"` // Per topic cost of the LOG opcodes
+	LogDataGas  uint64 `json:"logDataGas,omitempty"`  // Per byte cost of the data logged by the LOG opcodes
+
+	RefundQuotient uint64 `json:"refundQuotient,omitempty"` // Refunds are capped to gasUsed / RefundQuotient
+
+	TxGas                 uint64 `json:"txGas,omitempty"`                 // Base cost of a transaction not creating a contract
+	TxGasContractCreation uint64 `json:"txGasContractCreation,omitempty"` // Base cost of a contract creation transaction
+}
+
 // String implements the fmt.Stringer interface.
 func (c *ChainConfig) String() string {
 	var engine interface{}
@@ -365,7 +388,7 @@ func (c *ChainConfig) String() string {
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, Berlin: %v, YOLO v3: %v, Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, Berlin: %v, YOLO v3: %v, Gas costs: %v, Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -380,6 +403,7 @@ func (c *ChainConfig) String() string {
 		c.MuirGlacierBlock,
 		c.BerlinBlock,
 		c.YoloV3Block,
+		c.gasCostsBlock(),
 		engine,
 	)
 }
@@ -451,6 +475,106 @@ func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
 }
 
+// IsGasCosts returns whether num is either equal to the activation block of the
+// gas cost overrides or greater.
+func (c *ChainConfig) IsGasCosts(num *big.Int) bool {
+	return isForked(c.gasCostsBlock(), num)
+}
+
+// gasCosts returns the gas cost overrides active at block num, nil if none.
+func (c *ChainConfig) gasCosts(num *big.Int) *GasCostConfig {
+	if !c.IsGasCosts(num) {
+		return nil
+	}
+	return c.GasCosts
+}
+
+// gasCostsBlock returns the activation block of the gas cost overrides, nil if
+// there are none.
+func (c *ChainConfig) gasCostsBlock() *big.Int {
+	if c.GasCosts == nil {
+		return nil
+	}
+	return c.GasCosts.Block
+}
+
+// CreateGas returns the base gas cost of the CREATE opcode at block num.
+func (c *ChainConfig) CreateGas(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.CreateGas != 0 {
+		return costs.CreateGas
+	}
+	return CreateGas
+}
+
+// Create2Gas returns the base gas cost of the CREATE2 opcode at block num.
+func (c *ChainConfig) Create2Gas(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.Create2Gas != 0 {
+		return costs.Create2Gas
+	}
+	return Create2Gas
+}
+
+// Sha3WordGas returns the per word gas cost of the data hashed by the SHA3
+// opcode and of the initcode hashed by CREATE2 at block num.
+func (c *ChainConfig) Sha3WordGas(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.Sha3WordGas != 0 {
+		return costs.Sha3WordGas
+	}
+	return Sha3WordGas
+}
+
+// LogGas returns the base gas cost of the LOG opcodes at block num.
+func (c *ChainConfig) LogGas(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.LogGas != 0 {
+		return costs.LogGas
+	}
+	return LogGas
+}
+
+// LogTopicGas returns the per topic gas cost of the LOG opcodes at block num.
+func (c *ChainConfig) LogTopicGas(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.LogTopicGas != 0 {
+		return costs.LogTopicGas
+	}
+	return LogTopicGas
+}
+
+// LogDataGas returns the per byte gas cost of the data logged by the LOG
+// opcodes at block num.
+func (c *ChainConfig) LogDataGas(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.LogDataGas != 0 {
+		return costs.LogDataGas
+	}
+	return LogDataGas
+}
+
+// RefundQuotient returns the divisor of the gas used by a transaction at block
+// num, which caps the amount of gas refunded to it.
+func (c *ChainConfig) RefundQuotient(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.RefundQuotient != 0 {
+		return costs.RefundQuotient
+	}
+	return RefundQuotient
+}
+
+// TxGas returns the base gas cost of a transaction not creating a contract at
+// block num.
+func (c *ChainConfig) TxGas(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.TxGas != 0 {
+		return costs.TxGas
+	}
+	return TxGas
+}
+
+// TxGasContractCreation returns the base gas cost of a contract creation
+// transaction at block num, once Homestead is active.
+func (c *ChainConfig) TxGasContractCreation(num *big.Int) uint64 {
+	if costs := c.gasCosts(num); costs != nil && costs.TxGasContractCreation != 0 {
+		return costs.TxGasContractCreation
+	}
+	return TxGasContractCreation
+}
+
 // CheckCompatible checks whether scheduled fork transitions have been imported
 // with a mismatching chain configuration.
 func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
@@ -562,6 +686,17 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	if isForkIncompatible(c.gasCostsBlock(), newcfg.gasCostsBlock(), head) {
+		return newCompatError("gas costs fork block", c.gasCostsBlock(), newcfg.gasCostsBlock())
+	}
+	if c.IsGasCosts(head) {
+		// Both are active at the same block, the overrides themselves must match
+		stored, updated := *c.GasCosts, *newcfg.GasCosts
+		stored.Block, updated.Block = nil, nil
+		if stored != updated {
+			return newCompatError("gas cost overrides", c.gasCostsBlock(), newcfg.gasCostsBlock())
+		}
+	}
 	return nil
 }
 
@@ -87,6 +87,34 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     30,
 			},
 		},
+		{
+			stored:  &ChainConfig{GasCosts: &GasCostConfig{Block: big.NewInt(10), TxGas: 10000}},
+			new:     &ChainConfig{GasCosts: &GasCostConfig{Block: big.NewInt(10), TxGas: 20000}},
+			head:    9,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{GasCosts: &GasCostConfig{Block: big.NewInt(10), TxGas: 10000}},
+			new:    &ChainConfig{GasCosts: &GasCostConfig{Block: big.NewInt(10), TxGas: 20000}},
+			head:   10,
+			wantErr: &ConfigCompatError{
+				What:         "gas cost overrides",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{GasCosts: &GasCostConfig{Block: big.NewInt(10), TxGas: 10000}},
+			new:    &ChainConfig{},
+			head:   20,
+			wantErr: &ConfigCompatError{
+				What:         "gas costs fork block",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    nil,
+				RewindTo:     9,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -16,7 +16,11 @@
 
 package params
 
-import "math/big"
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
 
 const (
 	GasLimitBoundDivisor uint64 = 1024    // The bound divisor of the gas limit, used in update calculations.
@@ -71,6 +75,7 @@ const (
 	CreateGas             uint64 = 32000 // Once per CREATE operation & contract-creation transaction.
 	Create2Gas            uint64 = 32000 // Once per CREATE2 operation
 	SelfdestructRefundGas uint64 = 24000 // Refunded following a selfdestruct operation.
+	RefundQuotient        uint64 = 2     // Maximum refund quotient; max gas refund is gasUsed / RefundQuotient.
 	MemoryGas             uint64 = 3     // Times the address of the (highest referenced byte in memory + 1). NOTE: referencing happens on read, write and in instructions such as RETURN and CALL.
 
 	TxDataNonZeroGasFrontier  uint64 = 68   // Per byte of data attached to a transaction that is not equal to zero. NOTE: Not payable on data of calls between transactions.
@@ -78,6 +83,12 @@ const (
 	TxAccessListAddressGas    uint64 = 2400 // Per address specified in EIP 2930 access list
 	TxAccessListStorageKeyGas uint64 = 1900 // Per storage key specified in EIP 2930 access list
 
+	BlobTxBlobGasPerBlob uint64 = 1 << 17 // Gas consumption of a single data blob (== blob byte size)
+
+	HistoryServeWindow uint64 = 8192 // Number of recent block hashes served from state by EIP-2935
+
+	BeaconRootsHistoryBufferLength uint64 = 8191 // Number of parent beacon block roots kept in state by EIP-4788
+
 	// These have been changed during the course of the chain
 	CallGasFrontier              uint64 = 40  // Once per CALL operation & message call transaction.
 	CallGasEIP150                uint64 = 700 // Static portion of gas for CALL-derivates after EIP 150 (Tangerine)
@@ -148,3 +159,12 @@ var (
 	MinimumDifficulty      = big.NewInt(131072) // The minimum that the difficulty may ever be.
 	DurationLimit          = big.NewInt(13)     // The decision boundary on the blocktime duration used to determine whether difficulty should go up or not.
 )
+
+// HistoryStorageAddress is the EIP-2935 system contract storing the hashes of
+// recent blocks in a ring buffer of HistoryServeWindow slots.
+var HistoryStorageAddress = common.HexToAddress("0x0000F90827F1C53a10cb7A02335B175320002935")
+
+// BeaconRootsAddress is the EIP-4788 system contract storing the timestamps and
+// parent beacon block roots of recent blocks in a ring buffer of
+// BeaconRootsHistoryBufferLength entries.
+var BeaconRootsAddress = common.HexToAddress("0x000F3df6D732807Ef1319fB7B8bB8522d0Beac02")
@@ -129,8 +129,8 @@ func GetChainConfig(forkString string) (baseConfig *params.ChainConfig, eips []i
 		if eipNum, err := strconv.Atoi(eip); err != nil {
 			return nil, nil, fmt.Errorf("syntax error, invalid eip number %v", eipNum)
 		} else {
-			if !vm.ValidEip(eipNum) {
-				return nil, nil, fmt.Errorf("syntax error, invalid eip number %v", eipNum)
+			if err := vm.ValidEipOrError(eipNum); err != nil {
+				return nil, nil, fmt.Errorf("syntax error, %v", err)
 			}
 			eips = append(eips, eipNum)
 		}